@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// cacheCommand runs the cache subcommand: "size" reports the number of files
+// and bytes cached, "clear" removes all cached files and "path" prints the
+// cache directory.
+func cacheCommand(dir string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: sitemapped cache size|clear|path")
+	}
+	cache := &sitemap.Cache{Dir: dir}
+	switch args[0] {
+	case "size":
+		files, size, err := cache.Size()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("files=%d bytes=%d\n", files, size)
+	case "clear":
+		return cache.Clear()
+	case "path":
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Println(abs)
+	default:
+		return fmt.Errorf("unknown cache subcommand: %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// checker issues HEAD requests for URLs concurrently and writes each loc with
+// its status code, in the order the URLs were given.
+type checker struct {
+	ctx     context.Context
+	cache   *sitemap.Cache
+	w       io.Writer
+	pending chan chan string // results, in order; the buffer limits concurrency
+	done    chan error
+}
+
+func newChecker(ctx context.Context, cache *sitemap.Cache, w io.Writer, workers int) *checker {
+	c := &checker{
+		ctx:     ctx,
+		cache:   cache,
+		w:       w,
+		pending: make(chan chan string, max(workers, 1)),
+		done:    make(chan error, 1),
+	}
+	go c.run()
+	return c
+}
+
+// run writes results as they become available, keeping the first error.
+func (c *checker) run() {
+	var err error
+	for res := range c.pending {
+		line := <-res
+		if err == nil && line != "" {
+			_, err = io.WriteString(c.w, line)
+		}
+	}
+	c.done <- err
+}
+
+// Check schedules a HEAD request for loc. A failed request is logged and
+// written with status code 0.
+func (c *checker) Check(loc string) {
+	res := make(chan string, 1)
+	c.pending <- res
+	go func() {
+		status, err := c.cache.Head(c.ctx, loc)
+		if err != nil {
+			log.Printf("check failed: %v", err)
+		}
+		res <- fmt.Sprintf("%s\t%d\n", loc, status)
+	}()
+}
+
+// Wait blocks, until the results of all checks scheduled so far are written,
+// so that w can be written to or flushed by the caller.
+func (c *checker) Wait() {
+	res := make(chan string)
+	c.pending <- res
+	res <- "" // received by run after all earlier results, written as nothing
+}
+
+// Close waits for all pending requests and returns the first write error.
+func (c *checker) Close() error {
+	close(c.pending)
+	return <-c.done
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// failure is a record of a failed sitemap in the error log.
+type failure struct {
+	URL       string    `json:"url"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+	Status    int       `json:"status,omitempty"`
+}
+
+// errorLog appends a JSON record for each failed sitemap to a file, safe for
+// concurrent use.
+type errorLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openErrorLog(filename string) (*errorLog, error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &errorLog{f: f, enc: enc}, nil
+}
+
+// Write records a failure of the sitemap at url. If the error names the
+// sitemap, that failed, e.g. a child of an index, its URL is used instead.
+func (l *errorLog) Write(url string, err error) error {
+	rec := failure{URL: url, Error: err.Error(), Timestamp: time.Now().UTC()}
+	var (
+		serr *sitemap.StatusError
+		derr *sitemap.DecodeError
+	)
+	switch {
+	case errors.As(err, &serr):
+		rec.URL, rec.Status = serr.URL, serr.StatusCode
+	case errors.As(err, &derr):
+		rec.URL = derr.Source
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(rec)
+}
+
+func (l *errorLog) Close() error {
+	return l.f.Close()
+}
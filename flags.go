@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerFlag collects repeated "Key: Value" header flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var ss []string
+	for k, vs := range h {
+		for _, v := range vs {
+			ss = append(ss, k+": "+v)
+		}
+	}
+	return strings.Join(ss, ", ")
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("header must be in the form 'Key: Value', got %q", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	return nil
+}
+
+// hostFlag collects repeated "OLD=NEW" host rewrites, keyed by lowercase old
+// host.
+type hostFlag map[string]string
+
+func (h hostFlag) String() string {
+	var ss []string
+	for k, v := range h {
+		ss = append(ss, k+"="+v)
+	}
+	return strings.Join(ss, ", ")
+}
+
+func (h hostFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+		return fmt.Errorf("host rewrite must be in the form 'OLD=NEW', got %q", s)
+	}
+	h[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// generateCommand runs the generate subcommand, which writes sitemaps and a
+// sitemap index for a list of URLs, read from a file or stdin.
+func generateCommand(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: sitemapped generate BASEURL DIR [FILE]")
+	}
+	var r io.Reader = os.Stdin
+	if len(args) == 3 && args[2] != "-" {
+		f, err := os.Open(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := sitemap.Generate(r, args[1], args[0])
+	if err != nil {
+		return err
+	}
+	log.Printf("wrote %d URLs to %s", n, args[1])
+	return nil
+}
@@ -4,11 +4,12 @@ go 1.22.5
 
 require (
 	github.com/adrg/xdg v0.5.0
+	github.com/andybalholm/brotli v1.1.0
+	github.com/klauspost/compress v1.17.9
 	github.com/sethgrid/pester v1.2.0
+	golang.org/x/net v0.27.0
+	golang.org/x/text v0.16.0
+	golang.org/x/time v0.5.0
 )
 
-require (
-	golang.org/x/net v0.27.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
-)
+require golang.org/x/sys v0.22.0 // indirect
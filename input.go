@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isCSV returns true, if an input file should be read as CSV, which is the
+// case for a .csv extension or when a column is named.
+func isCSV(filename, column string) bool {
+	return column != "" || strings.EqualFold(filepath.Ext(filename), ".csv")
+}
+
+// readColumn returns the non-empty values of a column of a CSV file with a
+// header row, e.g. an export of sitemaps from Google Search Console. The
+// column is matched by name, ignoring case, and defaults to the first one.
+func readColumn(filename, column string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	// Spreadsheet exports often start with a byte order mark.
+	if b, err := br.Peek(3); err == nil && string(b) == "\ufeff" {
+		br.Discard(3)
+	}
+	r := csv.NewReader(br)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := 0
+	if column != "" {
+		index = -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("%s: no column %q in header", filename, column)
+		}
+	}
+	var values []string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index >= len(record) {
+			continue
+		}
+		if v := strings.TrimSpace(record[index]); v != "" {
+			values = append(values, v)
+		}
+	}
+}
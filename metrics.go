@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// serveMetrics serves the counters of cache and the number of URLs written by
+// uw at /metrics on addr, in the background, until the listener is closed.
+func serveMetrics(addr string, cache *sitemap.Cache, uw *urlWriter) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := cache.Stats.WriteMetrics(w); err != nil {
+			return
+		}
+		sitemap.WriteMetric(w, "urls_emitted_total", "URLs written, after filters.", uw.emitted.Load())
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("metrics: %v", err)
+		}
+	}()
+	return ln, nil
+}
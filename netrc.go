@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcLogin are the credentials of a machine in a .netrc file.
+type netrcLogin struct {
+	login    string
+	password string
+}
+
+// netrcPath returns the location of the .netrc file, as given by the NETRC
+// environment variable, or in the home directory.
+func netrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// loadNetrc reads credentials from a .netrc file, by machine name. The
+// credentials of a "default" entry are stored under the empty name. Macro
+// definitions are skipped.
+func loadNetrc(filename string) (map[string]netrcLogin, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		logins  = make(map[string]netrcLogin)
+		scanner = bufio.NewScanner(f)
+		machine string
+		current *netrcLogin
+		inMacro bool
+	)
+	save := func() {
+		if current != nil {
+			logins[machine] = *current
+		}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends with an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				save()
+				machine, current = value, &netrcLogin{}
+				i++
+			case "default":
+				save()
+				machine, current = "", &netrcLogin{}
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	save()
+	return logins, nil
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/miku/sitemapped/sitemap"
+)
+
+// record is a single URL in JSON or template output.
+type record struct {
+	Loc        string      `json:"loc"`
+	Lastmod    string      `json:"lastmod"`
+	Changefreq string      `json:"changefreq"`
+	Priority   string      `json:"priority"`
+	Source     string      `json:"source"`
+	Images     []string    `json:"images,omitempty"`
+	Videos     []video     `json:"videos,omitempty"`
+	Alternates []alternate `json:"alternates,omitempty"`
+	News       *news       `json:"news,omitempty"`
+}
+
+// csvHeader are the columns of CSV output.
+var csvHeader = []string{"loc", "lastmod", "changefreq", "priority", "source"}
+
+// news are the news sitemap fields of a URL in JSON output.
+type news struct {
+	Name            string `json:"name"`
+	Language        string `json:"language"`
+	PublicationDate string `json:"publication_date"`
+	Title           string `json:"title"`
+}
+
+// alternate is a language version of a URL in JSON output.
+type alternate struct {
+	Hreflang string `json:"hreflang"`
+	Href     string `json:"href"`
+}
+
+// video are the locations of a video in JSON output.
+type video struct {
+	ContentLoc string `json:"content_loc,omitempty"`
+	PlayerLoc  string `json:"player_loc,omitempty"`
+}
+
+// urlWriter writes URLs in the format selected by flags.
+type urlWriter struct {
+	w       io.Writer
+	enc     *json.Encoder
+	match   *regexp.Regexp      // if set, only write matching locs
+	exclude *regexp.Regexp      // if set, drop matching locs
+	seen    map[string]struct{} // if not nil, locs already written
+	count   int                 // number of URLs written
+	emitted atomic.Int64        // count, for reading from other goroutines
+	buf     []entry             // URLs kept for sorting
+	tmpl    *template.Template  // if set, used to format each URL
+	csv     *csv.Writer         // if set, URLs are written as CSV rows
+	check   *checker            // if set, URLs are checked and written with status
+	rand    *rand.Rand          // for shuffled output
+	split   *splitter           // if set, URLs of each sitemap are written to a separate file
+	freqs   map[string]bool     // if not nil, changefreq values to keep
+	unknown map[string]bool     // changefreq values not in the protocol, already warned about
+}
+
+// entry is a URL together with the sitemap it was found in.
+type entry struct {
+	source string
+	u      sitemap.URL
+}
+
+func newURLWriter(w io.Writer) *urlWriter {
+	uw := &urlWriter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	uw.setOutput(w)
+	return uw
+}
+
+// setOutput directs further output to w.
+func (uw *urlWriter) setOutput(w io.Writer) {
+	uw.w = w
+	uw.enc = json.NewEncoder(w)
+	uw.enc.SetEscapeHTML(false)
+}
+
+// WriteURL writes a single URL, found in sitemap source. It returns
+// sitemap.SkipAll, once the limit of URLs to write is reached. If sorting is
+// requested, URLs are buffered until Flush is called. If only counting, URLs
+// are counted, but not written.
+func (uw *urlWriter) WriteURL(source string, u *sitemap.URL) error {
+	loc := prepareLoc(source, u.Loc)
+	if loc != u.Loc {
+		v := *u
+		v.Loc = loc
+		u = &v
+	}
+	if *newsOnly && u.News == nil {
+		return nil
+	}
+	if uw.match != nil && !uw.match.MatchString(loc) {
+		return nil
+	}
+	if uw.exclude != nil && uw.exclude.MatchString(loc) {
+		return nil
+	}
+	if *onlyHost != "" && !hasHost(loc, *onlyHost) {
+		return nil
+	}
+	if *minPriority > 0 && u.PriorityValue() < *minPriority {
+		return nil
+	}
+	if uw.freqs != nil && !uw.keepChangefreq(u.Changefreq) {
+		return nil
+	}
+	if uw.seen != nil {
+		if _, ok := uw.seen[loc]; ok {
+			return nil
+		}
+		uw.seen[loc] = struct{}{}
+	}
+	switch {
+	case *countOnly:
+	case *shuffle:
+		uw.sample(entry{source: source, u: *u})
+	case *sortURLs || *sortHost:
+		uw.buf = append(uw.buf, entry{source: source, u: *u})
+	default:
+		if err := uw.write(source, u); err != nil {
+			return err
+		}
+	}
+	uw.count++
+	uw.emitted.Add(1)
+	if *limit > 0 && uw.count >= *limit && !*shuffle {
+		return sitemap.SkipAll
+	}
+	return nil
+}
+
+// sample keeps an entry for shuffled output. With a limit, a uniform random
+// sample of that many entries is kept, using reservoir sampling, so memory use
+// is bounded by the limit.
+func (uw *urlWriter) sample(e entry) {
+	if *limit <= 0 || len(uw.buf) < *limit {
+		uw.buf = append(uw.buf, e)
+		return
+	}
+	if i := uw.rand.Intn(uw.count + 1); i < *limit {
+		uw.buf[i] = e
+	}
+}
+
+// write formats and writes a single URL, with its loc already prepared by
+// WriteURL.
+func (uw *urlWriter) write(source string, u *sitemap.URL) error {
+	if uw.split != nil {
+		if err := uw.split.use(uw, source); err != nil {
+			return err
+		}
+	}
+	loc := u.Loc
+	var imageLocs []string
+	if *images {
+		for _, img := range u.Images {
+			imageLocs = append(imageLocs, strings.TrimSpace(img.Loc))
+		}
+	}
+	var (
+		videoRecords []video
+		videoLocs    []string
+	)
+	if *videos {
+		for _, v := range u.Videos {
+			v := video{
+				ContentLoc: strings.TrimSpace(v.ContentLoc),
+				PlayerLoc:  strings.TrimSpace(v.PlayerLoc),
+			}
+			videoRecords = append(videoRecords, v)
+			for _, s := range []string{v.ContentLoc, v.PlayerLoc} {
+				if s != "" {
+					videoLocs = append(videoLocs, s)
+				}
+			}
+		}
+	}
+	var alternates []alternate
+	if *hreflang {
+		for _, l := range u.Alternates() {
+			alternates = append(alternates, alternate{
+				Hreflang: strings.TrimSpace(l.Hreflang),
+				Href:     strings.TrimSpace(l.Href),
+			})
+		}
+	}
+	var newsRecord *news
+	if *newsOnly {
+		newsRecord = &news{
+			Name:            strings.TrimSpace(u.News.Publication.Name),
+			Language:        strings.TrimSpace(u.News.Publication.Language),
+			PublicationDate: strings.TrimSpace(u.News.PublicationDate),
+			Title:           strings.TrimSpace(u.News.Title),
+		}
+	}
+	var prefix string // source column for text output
+	if *withSource {
+		prefix = source + "\t"
+	}
+	rec := record{
+		Loc:        loc,
+		Lastmod:    strings.TrimSpace(u.Lastmod),
+		Changefreq: strings.TrimSpace(u.Changefreq),
+		Priority:   strings.TrimSpace(u.Priority),
+		Source:     source,
+		Images:     imageLocs,
+		Videos:     videoRecords,
+		Alternates: alternates,
+		News:       newsRecord,
+	}
+	var err error
+	switch {
+	case uw.tmpl != nil:
+		if err = uw.tmpl.Execute(uw.w, rec); err == nil {
+			_, err = io.WriteString(uw.w, "\n")
+		}
+		return err
+	case uw.check != nil:
+		uw.check.Check(loc)
+		return nil
+	case uw.csv != nil:
+		return uw.csv.Write([]string{rec.Loc, rec.Lastmod, rec.Changefreq, rec.Priority, rec.Source})
+	case *jsonOutput:
+		err = uw.enc.Encode(rec)
+	case *newsOnly:
+		_, err = fmt.Fprintf(uw.w, "%s%s\t%s\t%s\n", prefix, loc, newsRecord.PublicationDate, newsRecord.Title)
+	case *long:
+		_, err = fmt.Fprintf(uw.w, "%s%s\t%s\t%s\t%s\n",
+			prefix,
+			loc,
+			strings.TrimSpace(u.Lastmod),
+			strings.TrimSpace(u.Changefreq),
+			strings.TrimSpace(u.Priority))
+	default:
+		_, err = fmt.Fprintf(uw.w, "%s%s\n", prefix, loc)
+	}
+	if err != nil {
+		return err
+	}
+	if !*jsonOutput {
+		if err := uw.writeLocs(prefix, imageLocs); err != nil {
+			return err
+		}
+		if err := uw.writeLocs(prefix, videoLocs); err != nil {
+			return err
+		}
+		for _, a := range alternates {
+			if _, err := fmt.Fprintf(uw.w, "%s%s\t%s\n", prefix, a.Href, a.Hreflang); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered URLs, sorted or shuffled, if requested, and flushes
+// CSV output.
+func (uw *urlWriter) Flush() error {
+	switch {
+	case *shuffle:
+		uw.rand.Shuffle(len(uw.buf), func(i, j int) {
+			uw.buf[i], uw.buf[j] = uw.buf[j], uw.buf[i]
+		})
+	case *sortHost:
+		slices.SortStableFunc(uw.buf, compareHost)
+	case *sortURLs:
+		slices.SortStableFunc(uw.buf, func(a, b entry) int {
+			return strings.Compare(strings.TrimSpace(a.u.Loc), strings.TrimSpace(b.u.Loc))
+		})
+	}
+	for _, e := range uw.buf {
+		if err := uw.write(e.source, &e.u); err != nil {
+			return err
+		}
+	}
+	uw.buf = nil
+	if uw.csv != nil {
+		uw.csv.Flush()
+		return uw.csv.Error()
+	}
+	return nil
+}
+
+// compareHost orders entries by hostname, then path, then the full loc.
+func compareHost(a, b entry) int {
+	la, lb := strings.TrimSpace(a.u.Loc), strings.TrimSpace(b.u.Loc)
+	ua, erra := url.Parse(la)
+	ub, errb := url.Parse(lb)
+	if erra != nil || errb != nil {
+		return strings.Compare(la, lb)
+	}
+	if c := strings.Compare(ua.Hostname(), ub.Hostname()); c != 0 {
+		return c
+	}
+	if c := strings.Compare(ua.Path, ub.Path); c != 0 {
+		return c
+	}
+	return strings.Compare(la, lb)
+}
+
+// resolveURL resolves a relative loc against the URL of the sitemap it was
+// found in. Absolute locs and locs from sitemaps without an absolute URL, like
+// local files, are returned unchanged.
+func resolveURL(source, loc string) string {
+	base, err := url.Parse(source)
+	if err != nil || !base.IsAbs() {
+		return loc
+	}
+	ref, err := url.Parse(loc)
+	if err != nil || ref.IsAbs() {
+		return loc
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// prepareLoc applies trimming, unless -raw is set, as well as resolution,
+// normalization and host rewrites, as requested, to a loc found in sitemap
+// source. All emitted locs go through here.
+func prepareLoc(source, loc string) string {
+	if !*raw {
+		loc = strings.TrimSpace(loc)
+	}
+	if *relative {
+		loc = resolveURL(source, loc)
+	}
+	if *normalize {
+		loc = normalizeURL(loc)
+	}
+	if len(rewrites) > 0 {
+		loc = rewriteHost(loc, rewrites)
+	}
+	return loc
+}
+
+// changefreqs are the values of changefreq defined by the sitemap protocol.
+var changefreqs = []string{"always", "hourly", "daily", "weekly", "monthly", "yearly", "never"}
+
+// parseChangefreqs parses a comma separated list of changefreq values.
+func parseChangefreqs(s string) (map[string]bool, error) {
+	freqs := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if !slices.Contains(changefreqs, v) {
+			return nil, fmt.Errorf("invalid changefreq %q, want one of %s", v, strings.Join(changefreqs, ", "))
+		}
+		freqs[v] = true
+	}
+	return freqs, nil
+}
+
+// keepChangefreq returns true, if a URL with changefreq s passes the filter.
+// Values not defined by the protocol never match and are logged once.
+func (uw *urlWriter) keepChangefreq(s string) bool {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if uw.freqs[v] {
+		return true
+	}
+	if v != "" && !slices.Contains(changefreqs, v) && !uw.unknown[v] {
+		if uw.unknown == nil {
+			uw.unknown = make(map[string]bool)
+		}
+		uw.unknown[v] = true
+		log.Printf("skipping URLs with unknown changefreq %q", s)
+	}
+	return false
+}
+
+// hasHost returns true, if the hostname of a URL is host, ignoring case and
+// port.
+func hasHost(s, host string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), host)
+}
+
+// rewriteHost replaces the host of a URL, if its hostname is listed in
+// rewrites, ignoring case and port like hasHost. Scheme, port, path and query
+// are kept, unless the new host has a port. Other URLs are returned unchanged.
+func rewriteHost(s string, rewrites hostFlag) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	host, ok := rewrites[strings.ToLower(u.Hostname())]
+	if !ok {
+		return s
+	}
+	if port := u.Port(); port != "" {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, port)
+		}
+	}
+	u.Host = host
+	return u.String()
+}
+
+// normalizeURL returns a canonical form of a URL, with lowercase scheme and
+// host, without default port, with dot segments resolved and an empty path
+// replaced by "/". Unparsable URLs are returned unchanged.
+func normalizeURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	switch u.Scheme {
+	case "http":
+		u.Host = strings.TrimSuffix(u.Host, ":80")
+	case "https":
+		u.Host = strings.TrimSuffix(u.Host, ":443")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	// An absolute reference has its dot segments removed.
+	return u.ResolveReference(&url.URL{
+		Path:        u.Path,
+		RawPath:     u.RawPath,
+		RawQuery:    u.RawQuery,
+		Fragment:    u.Fragment,
+		RawFragment: u.RawFragment,
+	}).String()
+}
+
+// writeLocs writes additional locations, like images, one per line, in plain
+// or long format, each prefixed with prefix.
+func (uw *urlWriter) writeLocs(prefix string, locs []string) error {
+	for _, loc := range locs {
+		var err error
+		if *long {
+			_, err = fmt.Fprintf(uw.w, "%s%s\t\t\t\n", prefix, loc)
+		} else {
+			_, err = fmt.Fprintf(uw.w, "%s%s\n", prefix, loc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// atomicFile is a file, that only appears under its final name after a
+// successful Close.
+type atomicFile struct {
+	*os.File
+	name string
+}
+
+// createAtomic creates a temporary file next to name, creating parent
+// directories as needed.
+func createAtomic(name string) (*atomicFile, error) {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(name)+".*.wip")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, name: name}, nil
+}
+
+// Close closes the temporary file and renames it to its final name.
+func (f *atomicFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.File.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+// Abort closes and removes the temporary file.
+func (f *atomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// checkpoint records inputs and child sitemaps, whose URLs were written
+// completely, one per line in a file, so they can be skipped, when resuming an
+// interrupted run.
+type checkpoint struct {
+	done map[string]bool
+	f    *os.File
+}
+
+// openCheckpoint reads the sitemaps done in earlier runs from filename, if it
+// exists, and opens it for appending.
+func openCheckpoint(filename string) (*checkpoint, error) {
+	lines, err := readLines(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	done := make(map[string]bool)
+	for _, line := range lines {
+		done[line] = true
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{done: done, f: f}, nil
+}
+
+// Done returns true, if a sitemap was done in an earlier run.
+func (c *checkpoint) Done(loc string) bool {
+	return c.done[loc]
+}
+
+// Add records a sitemap as done. Its URLs must have been written before.
+func (c *checkpoint) Add(loc string) error {
+	_, err := fmt.Fprintln(c.f, loc)
+	return err
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,145 @@
+package sitemap
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheBackend is a store for cached sitemaps, e.g. shared between machines.
+// Keys are slash separated paths relative to the cache directory.
+type CacheBackend interface {
+	// Get returns the content stored under key.
+	Get(key string) (io.ReadCloser, error)
+	// Put stores the content of r under key.
+	Put(key string, r io.Reader) error
+	// Exists reports whether content is stored under key.
+	Exists(key string) (bool, error)
+}
+
+// DirBackend is a CacheBackend storing files in a directory, e.g. on a
+// network filesystem.
+type DirBackend struct {
+	Dir string
+}
+
+func (b *DirBackend) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(b.Dir, filepath.FromSlash(key)))
+}
+
+func (b *DirBackend) Put(key string, r io.Reader) error {
+	dst := filepath.Join(b.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	_, err := writeFileAtomic(dst, r)
+	return err
+}
+
+func (b *DirBackend) Exists(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(b.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeFileAtomic writes the content of r to dst, via a temporary file in the
+// same directory, returning the number of bytes written. The temporary file
+// is unique, as the same file may be written concurrently.
+func writeFileAtomic(dst string, r io.Reader) (n int64, err error) {
+	f, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.wip")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if n, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), dst)
+}
+
+// key returns the backend key of a file in the cache directory.
+func (c *Cache) key(filename string) string {
+	rel, err := filepath.Rel(c.Dir, filename)
+	if err != nil {
+		return filepath.Base(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// restore copies a file from the backend to dst, if the backend has it, and
+// reports whether it did. The sidecar with validators is restored, too, and
+// the file is dated to the time it was fetched, or to the Unix epoch, if that
+// is unknown, so that MaxAge, Incremental and Refresh do not take the copy
+// for a fresh one.
+func (c *Cache) restore(dst string) (bool, error) {
+	ok, err := c.copyFromBackend(dst)
+	if err != nil || !ok {
+		return false, err
+	}
+	ok, err = c.copyFromBackend(dst + ".meta")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// A sidecar left from an earlier copy does not belong to this one.
+		if err := os.Remove(dst + ".meta"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+	}
+	fetched := time.Unix(0, 0)
+	if v := readValidators(dst); v != nil && !v.Fetched.IsZero() {
+		fetched = v.Fetched
+	}
+	return true, os.Chtimes(dst, fetched, fetched)
+}
+
+// copyFromBackend copies a file from the backend to dst, if the backend has
+// it, and reports whether it did.
+func (c *Cache) copyFromBackend(dst string) (bool, error) {
+	key := c.key(dst)
+	ok, err := c.Backend.Exists(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	rc, err := c.Backend.Get(key)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	if _, err := writeFileAtomic(dst, rc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// store copies a downloaded file and its sidecar to the backend.
+func (c *Cache) store(filename string) error {
+	for _, name := range []string{filename, filename + ".meta"} {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = c.Backend.Put(c.key(name), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRestoreKeepsFetchTime(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"></urlset>`)
+	}))
+	defer srv.Close()
+	var (
+		ctx     = context.Background()
+		backend = &DirBackend{Dir: t.TempDir()}
+		url     = srv.URL + "/sitemap.xml"
+	)
+	c := &Cache{Dir: t.TempDir(), Client: http.DefaultClient, Backend: backend}
+	filename, err := c.URL(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := c.key(filename)
+	for _, name := range []string{key, key + ".meta"} {
+		if ok, err := backend.Exists(name); err != nil || !ok {
+			t.Fatalf("backend has no %s: %v", name, err)
+		}
+	}
+	// Date the copy in the backend back, as if fetched by another machine.
+	fetched := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	b, err := json.Marshal(validators{Fetched: fetched})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaFile := filepath.Join(backend.Dir, filepath.FromSlash(key+".meta"))
+	if err := os.WriteFile(metaFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		noMeta   bool
+		maxAge   time.Duration
+		wantTime time.Time
+		wantReqs int64
+	}{
+		{"fresh enough", false, 72 * time.Hour, fetched, 0},
+		{"expired", false, time.Hour, time.Time{}, 1},
+		{"unknown fetch time", true, 72 * time.Hour, time.Time{}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.noMeta {
+				if err := os.Remove(metaFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			requests.Store(0)
+			c := &Cache{Dir: t.TempDir(), Client: http.DefaultClient, Backend: backend, MaxAge: tc.maxAge}
+			filename, err := c.URL(ctx, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := requests.Load(); n != tc.wantReqs {
+				t.Errorf("got %d requests, want %d", n, tc.wantReqs)
+			}
+			if tc.wantTime.IsZero() {
+				return
+			}
+			fi, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.ModTime().Equal(tc.wantTime) {
+				t.Errorf("got mtime %v, want %v", fi.ModTime(), tc.wantTime)
+			}
+		})
+	}
+}
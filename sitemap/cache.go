@@ -0,0 +1,564 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrg/xdg"
+	"golang.org/x/time/rate"
+)
+
+// DefaultMaxDepth is the default limit for nested sitemap indices.
+const DefaultMaxDepth = 4
+
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+
+// DefaultCacheDir is the default location for cached sitemaps.
+var DefaultCacheDir = path.Join(xdg.CacheHome, "sitemap")
+
+// Cache keeps downloaded sitemaps on disk.
+type Cache struct {
+	Dir        string
+	Client     Doer
+	UserAgent  string
+	UserAgents []string    // if set, used round-robin per request instead of UserAgent
+	Header     http.Header // additional headers to send with each request
+	Username   string      // for basic auth, if not empty
+	Password   string
+	Force      bool             // redownload sitemaps, even if cached
+	Workers    int              // number of child sitemaps to fetch in parallel
+	ShardDepth int              // levels of cache subdirectories, 1 if zero
+	NewHash    func() hash.Hash // derives cache keys from URLs, sha1.New if nil
+	Layout     CacheLayout      // location of files in the cache dir, LayoutHash if zero
+	Refresh    bool             // revalidate cached files with conditional requests
+	Limiter    *rate.Limiter    // if set, limits the rate of requests
+	MaxAge     time.Duration    // treat cached files older than this as missing, if not zero
+	MaxDepth   int              // max levels of nested sitemap indices, DefaultMaxDepth if zero
+	Validate   bool             // warn about sitemaps exceeding protocol limits
+	SaveErrors bool             // cache error responses and non-sitemap content, too
+	MaxSize    int64            // max bytes per download, DefaultMaxSize if zero, no limit if negative
+	Since      time.Time        // skip index entries with an earlier lastmod, if not zero
+	Until      time.Time        // skip index entries with a later lastmod, if not zero
+	KeepGoing  bool             // log and skip child sitemaps that fail, counted in Stats.Failed
+	Backend    CacheBackend     // if set, consulted for files missing locally and updated after downloads
+	Logger     *log.Logger      // if set, warnings are logged here
+	Debug      bool             // log fetches, cache decisions and decompression, too
+	Progress   ProgressFunc     // if set, called for each child sitemap of an index
+	Stats      Stats            // counters, updated during use
+
+	uaNext atomic.Uint64 // index of the next user agent in UserAgents
+
+	// Incremental redownloads child sitemaps only, if their lastmod in the
+	// index is newer than the cached copy. Entries without lastmod follow
+	// MaxAge and Refresh.
+	Incremental bool
+
+	// SkipUnchanged skips child sitemaps of an index, that were not
+	// downloaded anew, because the cached copy is current or unchanged on
+	// the server, e.g. to only see URLs of changed sitemaps with Refresh.
+	SkipUnchanged bool
+
+	// Credentials returns basic auth credentials for a host, e.g. from a
+	// .netrc file. If set, it is used for requests, when Username is empty.
+	Credentials func(host string) (username, password string, ok bool)
+
+	// OnError, if set, is called with the location and error of each child
+	// sitemap, that is skipped with KeepGoing.
+	OnError func(loc string, err error)
+
+	// Skip, if set, is called for each child sitemap of an index, which is
+	// not fetched, if it returns true, e.g. as it was done in an earlier run.
+	Skip func(loc string) bool
+
+	// Completed, if set, is called after all URLs of a child sitemap of an
+	// index were passed to the walk function.
+	Completed func(loc string)
+
+	// MaxSitemaps, if not zero, limits the child sitemaps processed per
+	// index to the first ones, that pass the lastmod and Skip filters.
+	MaxSitemaps int
+
+	// SitemapTimeout, if not zero, limits the time to fetch and parse a
+	// single child sitemap of an index, including retries. A child taking
+	// longer fails, and is skipped with KeepGoing.
+	SitemapTimeout time.Duration
+
+	// ResolveRelative resolves relative locs of index entries against the
+	// URL of the index, before they are fetched. Otherwise they fail.
+	ResolveRelative bool
+}
+
+// Stats are counters collected while using a cache, safe for concurrent use.
+type Stats struct {
+	Sitemaps        atomic.Int64 // sitemaps processed
+	URLs            atomic.Int64 // URLs found and passed to walk functions
+	CacheHits       atomic.Int64 // files served from cache, including revalidated ones
+	CacheMisses     atomic.Int64 // files downloaded
+	BytesDownloaded atomic.Int64 // bytes written to cache
+	FetchErrors     atomic.Int64 // fetches that failed, after retries
+	Failed          atomic.Int64 // child sitemaps skipped due to errors, with KeepGoing
+	ImageSitemaps   atomic.Int64 // urlsets declaring the image extension namespace
+	VideoSitemaps   atomic.Int64 // urlsets declaring the video extension namespace
+	NewsSitemaps    atomic.Int64 // urlsets declaring the news extension namespace
+	XHTMLSitemaps   atomic.Int64 // urlsets declaring the xhtml namespace, for alternate links
+}
+
+func (c *Cache) logf(format string, v ...any) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// debugf logs, if debug logging is enabled.
+func (c *Cache) debugf(format string, v ...any) {
+	if c.Debug {
+		c.logf(format, v...)
+	}
+}
+
+func (c *Cache) maxDepth() int {
+	if c.MaxDepth > 0 {
+		return c.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+// shard returns the directory for a digest, relative to the cache dir, using
+// two hex characters per level, e.g. "ab/cd" for a shard depth of 2.
+func (c *Cache) shard(digest string) string {
+	var parts []string
+	for i := 0; i < max(c.ShardDepth, 1) && 2*i+2 <= len(digest); i++ {
+		parts = append(parts, digest[2*i:2*i+2])
+	}
+	return path.Join(parts...)
+}
+
+type DownloadOpts struct {
+	Filename string    // a specific filename to use, if any
+	Force    bool      // attempt redownload in any case
+	Lastmod  time.Time // if set, decides freshness of a cached copy instead of MaxAge and Refresh
+}
+
+// URL returns the path to cached file for a given URL. If force is true,
+// redownload, even if copy exists. Copies older than MaxAge are redownloaded.
+// If the cache is in refresh mode, a cached copy is revalidated with the
+// server, using the time of the last fetch, if the server sent no validators.
+// If a last modification time is given in opts, a cached copy is used as is, if
+// it is newer, and redownloaded otherwise.
+func (c *Cache) URL(ctx context.Context, url string, opts *DownloadOpts) (string, error) {
+	filename, _, err := c.fetch(ctx, url, opts)
+	return filename, err
+}
+
+// fetch is like URL, but also reports, whether new content was downloaded.
+func (c *Cache) fetch(ctx context.Context, url string, opts *DownloadOpts) (filename string, changed bool, err error) {
+	if opts == nil {
+		opts = &DownloadOpts{}
+	}
+	dir := c.Dir
+	filename = opts.Filename
+	switch {
+	case filename != "":
+	case c.Layout == LayoutURL:
+		rel := urlFilename(url)
+		dir, filename = path.Join(c.Dir, path.Dir(rel)), path.Base(rel)
+	default:
+		newHash := c.NewHash
+		if newHash == nil {
+			newHash = sha1.New
+		}
+		h := newHash()
+		_, _ = h.Write([]byte(url))
+		filename = fmt.Sprintf("%x", h.Sum(nil))
+		dir = path.Join(c.Dir, c.shard(filename))
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", false, err
+		}
+	}
+	dst := path.Join(dir, filename)
+	fi, err := os.Stat(dst)
+	if os.IsNotExist(err) && !opts.Force && c.Backend != nil {
+		ok, rerr := c.restore(dst)
+		if rerr != nil {
+			return "", false, rerr
+		}
+		if ok {
+			c.debugf("restored from backend: %s", url)
+			fi, err = os.Stat(dst)
+		}
+	}
+	switch {
+	case os.IsNotExist(err) || opts.Force:
+		changed, err = c.download(ctx, url, dst, nil)
+	case err != nil:
+		return "", false, err
+	case !opts.Lastmod.IsZero() && fi.ModTime().Before(opts.Lastmod):
+		c.debugf("modified since cached: %s", url)
+		changed, err = c.download(ctx, url, dst, nil)
+	case !opts.Lastmod.IsZero():
+		c.debugf("cache hit, not modified since cached: %s", url)
+		c.Stats.CacheHits.Add(1)
+	case c.MaxAge > 0 && time.Since(fi.ModTime()) > c.MaxAge:
+		changed, err = c.download(ctx, url, dst, nil)
+	case c.Refresh:
+		v := readValidators(dst)
+		if v == nil {
+			v = &validators{}
+		}
+		if v.ETag == "" && v.LastModified == "" {
+			// The time of the last fetch is kept as modification time.
+			v.LastModified = fi.ModTime().UTC().Format(http.TimeFormat)
+		}
+		changed, err = c.download(ctx, url, dst, v)
+	default:
+		c.debugf("cache hit: %s", url)
+		c.Stats.CacheHits.Add(1)
+	}
+	if err != nil {
+		c.Stats.FetchErrors.Add(1)
+		return "", false, err
+	}
+	return dst, changed, nil
+}
+
+// validators are HTTP cache validators of a cached file, kept in a sidecar
+// file next to it.
+type validators struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched,omitempty"` // time of download, kept for copies restored from a backend
+}
+
+// readValidators returns the validators stored for a cached file, or nil.
+func readValidators(dst string) *validators {
+	b, err := os.ReadFile(dst + ".meta")
+	if err != nil {
+		return nil
+	}
+	var v validators
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return &v
+}
+
+// userAgent returns the user agent for the next request.
+func (c *Cache) userAgent() string {
+	if len(c.UserAgents) == 0 {
+		return c.UserAgent
+	}
+	i := c.uaNext.Add(1) - 1
+	return c.UserAgents[i%uint64(len(c.UserAgents))]
+}
+
+// newRequest returns a request for url, with user agent, additional headers
+// and credentials set.
+func (c *Cache) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	} else {
+		c.setHostCredentials(req)
+	}
+	return req, nil
+}
+
+// setHostCredentials sets basic auth from the Credentials for the host of
+// req, if any.
+func (c *Cache) setHostCredentials(req *http.Request) {
+	if c.Credentials == nil {
+		return
+	}
+	if username, password, ok := c.Credentials(req.URL.Hostname()); ok {
+		req.SetBasicAuth(username, password)
+	}
+}
+
+// wait blocks until the rate limit allows a request. If that would be after
+// the deadline of ctx, it blocks until the deadline and returns ctx.Err(), as
+// other operations bound by ctx do.
+func (c *Cache) wait(ctx context.Context) error {
+	if c.Limiter == nil {
+		return nil
+	}
+	err := c.Limiter.Wait(ctx)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); ok {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+// download fetches url into dst. If validators are given, a conditional
+// request is issued and dst is kept, if the server responds with 304. It
+// reports, whether new content was downloaded.
+func (c *Cache) download(ctx context.Context, url, dst string, v *validators) (changed bool, err error) {
+	if err := c.wait(ctx); err != nil {
+		return false, err
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return false, err
+	}
+	if v != nil {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+	c.debugf("fetching %s", url)
+	resp, n, err := saveResponse(c.Client, req, dst, !c.SaveErrors, c.maxSize())
+	if err != nil {
+		return false, err
+	}
+	c.debugf("%s: %s, %d bytes, content encoding %q", url, resp.Status, n, resp.Header.Get("Content-Encoding"))
+	if resp.Request != nil && resp.Request.URL.String() != url {
+		c.debugf("%s: redirected to %s", url, resp.Request.URL)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		c.Stats.CacheHits.Add(1)
+		now := time.Now()
+		return false, os.Chtimes(dst, now, now)
+	}
+	c.Stats.CacheMisses.Add(1)
+	c.Stats.BytesDownloaded.Add(n)
+	v = &validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now().UTC(),
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(dst+".meta", b, 0644); err != nil {
+		return false, err
+	}
+	if c.Backend != nil {
+		if err := c.store(dst); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Head issues a HEAD request for url, e.g. to check whether it is live, and
+// returns the status code. The request uses the same client, user agent and
+// rate limit as downloads. As url may be on any host, Header, Username and
+// Password are not sent, only Credentials for its host.
+func (c *Cache) Head(ctx context.Context, url string) (int, error) {
+	if err := c.wait(ctx); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	c.setHostCredentials(req)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// RemoveStale removes temporary files of interrupted downloads, that are older
+// than maxAge, and returns the number of files removed.
+func (c *Cache) RemoveStale(maxAge time.Duration) (int, error) {
+	var n int
+	err := filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".wip") {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(fi.ModTime()) < maxAge {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Size returns the number of files and their total size in bytes in the
+// cache directory.
+func (c *Cache) Size() (files int, size int64, err error) {
+	err = filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		size += fi.Size()
+		return nil
+	})
+	return files, size, err
+}
+
+// Clear removes all contents of the cache directory, but keeps the directory.
+func (c *Cache) Clear() error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(c.Dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// DownloadFile retrieves a file from URL, atomically. If the context is
+// cancelled or the file exceeds DefaultMaxSize, the download is aborted and no
+// partial file is left behind.
+func DownloadFile(ctx context.Context, client Doer, url string, dst string, userAgent string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	_, _, err = saveResponse(client, req, dst, true, DefaultMaxSize)
+	return err
+}
+
+// saveResponse performs a request and saves the response body to dst,
+// atomically, returning the number of bytes written. A 304 Not Modified
+// response leaves dst untouched. A gzip content encoding is removed, so the
+// saved file is the sitemap itself. If strict, responses with a status other
+// than 2xx and, except for robots.txt, responses that do not look like a
+// sitemap, like HTML error pages, are not saved. Downloads saving more than
+// maxSize bytes fail, unless maxSize is negative.
+func saveResponse(client Doer, req *http.Request, dst string, strict bool, maxSize int64) (resp *http.Response, n int64, err error) {
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, 0, nil
+	}
+	if strict && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		serr := &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+		if loc, err := resp.Location(); err == nil {
+			serr.Location = loc.String()
+		}
+		return nil, 0, serr
+	}
+	body, err := decodeContent(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+	br := bufio.NewReader(body)
+	if strict && !IsRobotsURL(req.URL.String()) {
+		head, _ := br.Peek(512)
+		if !looksLikeSitemap(head) {
+			return nil, 0, fmt.Errorf("%s: %w (%s, %s): %q",
+				req.URL, ErrNotSitemap, resp.Status, resp.Header.Get("Content-Type"), preview(head))
+		}
+	}
+	var r io.Reader = br
+	if maxSize >= 0 {
+		r = &limitedReader{r: br, n: maxSize}
+	}
+	if n, err = writeFileAtomic(dst, r); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return nil, 0, fmt.Errorf("%s: %w of %d bytes", req.URL, err, maxSize)
+		}
+		return nil, 0, err
+	}
+	return resp, n, nil
+}
+
+// looksLikeSitemap returns true, if the beginning of a file looks like
+// compressed data, UTF-16, a plain text list of URLs or XML other than HTML.
+func looksLikeSitemap(head []byte) bool {
+	if bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, zstdMagic) {
+		return true
+	}
+	if bytes.HasPrefix(head, utf16LEBOM) || bytes.HasPrefix(head, utf16BEBOM) {
+		return true
+	}
+	if looksLikeText(head) {
+		return true
+	}
+	head = bytes.TrimPrefix(head, utf8BOM)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if !bytes.HasPrefix(head, []byte("<")) {
+		return false
+	}
+	lower := bytes.ToLower(head)
+	return !bytes.HasPrefix(lower, []byte("<!doctype html")) && !bytes.HasPrefix(lower, []byte("<html"))
+}
+
+// preview returns the beginning of a response for error messages.
+func preview(head []byte) string {
+	const n = 64
+	if len(head) > n {
+		head = head[:n]
+	}
+	return strings.ToValidUTF8(string(head), "?")
+}
@@ -0,0 +1,106 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// readCloser reads from a reader and closes a list of closers, in order.
+type readCloser struct {
+	io.Reader
+	closers  []io.Closer
+	encoding string // compression detected, if any
+}
+
+func (rc *readCloser) Close() error {
+	var err error
+	for _, c := range rc.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openDecompressed opens a file and transparently decompresses it, if its
+// content looks like gzip or zstd, regardless of the filename. Brotli has no
+// magic bytes, so it is only recognized by a .br filename extension.
+func openDecompressed(filename string) (*readCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(filename, ".br") {
+		return &readCloser{Reader: brotli.NewReader(f), closers: []io.Closer{f}, encoding: "br"}, nil
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(len(zstdMagic)) // short files are not compressed
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr, f}, encoding: "gzip"}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), f}, encoding: "zstd"}, nil
+	default:
+		return &readCloser{Reader: br, closers: []io.Closer{f}}, nil
+	}
+}
+
+// decodeContent returns the response body with a gzip, brotli or zstd
+// Content-Encoding removed. The transport only does this itself, if it asked
+// for compression, but some servers compress regardless. Bodies without gzip
+// magic bytes are passed through as is, despite the header. Brotli compressed
+// files, recognized by a .br extension, are decompressed as well, as they
+// cannot be detected later.
+func decodeContent(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); {
+	case encoding == "gzip" || encoding == "x-gzip":
+	case encoding == "br" || encoding == "" && hasPathSuffix(resp, ".br"):
+		return &readCloser{Reader: brotli.NewReader(resp.Body), closers: []io.Closer{resp.Body}}, nil
+	case encoding == "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), resp.Body}}, nil
+	default:
+		return resp.Body, nil
+	}
+	br := bufio.NewReader(resp.Body)
+	magic, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return &readCloser{Reader: br, closers: []io.Closer{resp.Body}}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: zr, closers: []io.Closer{zr, resp.Body}}, nil
+}
+
+// hasPathSuffix returns true, if the path of the requested URL ends with suffix.
+func hasPathSuffix(resp *http.Response, suffix string) bool {
+	return resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, suffix)
+}
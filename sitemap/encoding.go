@@ -0,0 +1,43 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// newDecoder returns an XML decoder for r. A byte order mark is removed and
+// UTF-16 input is converted to UTF-8, in which case the declared encoding is
+// ignored, as it would be applied to the converted data otherwise. Other
+// declared encodings, like ISO-8859-1, are converted as well.
+func newDecoder(r io.Reader) *xml.Decoder {
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(len(utf8BOM))
+	var dec *xml.Decoder
+	switch {
+	case bytes.HasPrefix(bom, utf8BOM):
+		_, _ = br.Discard(len(utf8BOM))
+		dec = xml.NewDecoder(br)
+	case bytes.HasPrefix(bom, utf16LEBOM) || bytes.HasPrefix(bom, utf16BEBOM):
+		dec = xml.NewDecoder(transform.NewReader(br, unicode.BOMOverride(transform.Nop)))
+		dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+		return dec
+	default:
+		dec = xml.NewDecoder(br)
+	}
+	dec.CharsetReader = charset.NewReaderLabel
+	return dec
+}
@@ -0,0 +1,42 @@
+package sitemap
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestURLsFromSitemapEncoding(t *testing.T) {
+	want := []string{"https://example.com/café", "https://example.com/straße"}
+	for _, name := range []string{
+		"utf8.xml",
+		"utf8-bom.xml",
+		"utf16le.xml",
+		"utf16be.xml",
+		"latin1.xml",
+		"windows1252.xml",
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var (
+				c    Cache
+				locs []string
+			)
+			err = c.urlsFromSitemap(name, f, func(source string, u *URL) error {
+				locs = append(locs, u.Loc)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(locs, want) {
+				t.Errorf("got %q, want %q", locs, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSitemap is returned, if a response does not look like a sitemap, e.g.
+// an HTML error page served with status 200.
+var ErrNotSitemap = errors.New("response does not look like a sitemap")
+
+// StatusError is returned, if a server responds with a status other than 2xx.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string // e.g. "404 Not Found"
+	Location   string // target of a redirect, that was not followed, if any
+}
+
+func (e *StatusError) Error() string {
+	if e.Location != "" {
+		return fmt.Sprintf("%s: %s to %s", e.URL, e.Status, e.Location)
+	}
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// DecodeError is returned, if a sitemap, sitemap index or feed cannot be
+// parsed. If known, the position of the failure in the uncompressed content
+// is included.
+type DecodeError struct {
+	Source string // URL or filename of the sitemap
+	Line   int    // line of the failure, zero if unknown
+	Offset int64  // byte offset of the failure, valid if Line is not zero
+	Err    error
+}
+
+// newDecodeError returns a DecodeError at the current position of dec.
+func newDecodeError(source string, dec *xml.Decoder, err error) *DecodeError {
+	line, _ := dec.InputPos()
+	return &DecodeError{Source: source, Line: line, Offset: dec.InputOffset(), Err: err}
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s: line %d, byte offset %d: %v", e.Source, e.Line, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Source, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,83 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// feedItem is an item of an RSS 2.0 feed or an entry of an Atom feed.
+type feedItem struct {
+	Links   []feedLink `xml:"link"`
+	PubDate string     `xml:"pubDate"` // RSS
+	Updated string     `xml:"updated"` // Atom
+}
+
+// feedLink is a link element, with the URL as text in RSS and as href
+// attribute in Atom.
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+// loc returns the URL of the item, if any.
+func (it *feedItem) loc() string {
+	for _, l := range it.Links {
+		if s := strings.TrimSpace(l.Text); s != "" {
+			return s
+		}
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
+}
+
+// lastmod returns the modification date of the item, converted to RFC3339 for
+// RSS.
+func (it *feedItem) lastmod() string {
+	if s := strings.TrimSpace(it.Updated); s != "" {
+		return s
+	}
+	s := strings.TrimSpace(it.PubDate)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return s
+}
+
+// urlsFromFeed calls walkFn with the link of each item of an RSS feed or each
+// entry of an Atom feed, read from dec after the root element.
+func (c *Cache) urlsFromFeed(source string, dec *xml.Decoder, walkFn WalkFunc) error {
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return newDecodeError(source, dec, err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "item" && se.Name.Local != "entry") {
+			continue
+		}
+		var it feedItem
+		if err := dec.DecodeElement(&it, &se); err != nil {
+			return newDecodeError(source, dec, err)
+		}
+		loc := it.loc()
+		if loc == "" {
+			continue
+		}
+		c.validateLoc(source, loc)
+		c.Stats.URLs.Add(1)
+		if err := walkFn(source, &URL{Loc: loc, Lastmod: it.lastmod()}); err != nil {
+			return err
+		}
+	}
+}
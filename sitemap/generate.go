@@ -0,0 +1,105 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Generate reads URLs, one per line, from r and writes them as sitemaps into
+// dir, named sitemap-1.xml, sitemap-2.xml and so on, each within the protocol
+// limits of MaxURLs URLs and MaxBytes bytes. A sitemap index, sitemap.xml,
+// lists all sitemaps, with their locations resolved against baseURL. It
+// returns the number of URLs written.
+func Generate(r io.Reader, dir, baseURL string) (int, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	const overhead = 1024 // XML declaration, root element and indentation
+	var (
+		urlset  = &Urlset{Xmlns: SitemapNamespace}
+		smi     = &Sitemapindex{Xmlns: SitemapNamespace}
+		size    int // estimated size of the current sitemap
+		n       int
+		buf     bytes.Buffer
+		scanner = bufio.NewScanner(r)
+	)
+	flush := func() error {
+		if len(urlset.URL) == 0 {
+			return nil
+		}
+		name := fmt.Sprintf("sitemap-%d.xml", len(smi.Sitemap)+1)
+		if err := writeXML(filepath.Join(dir, name), urlset); err != nil {
+			return err
+		}
+		smi.Sitemap = append(smi.Sitemap, SitemapIndexEntry{
+			Loc: base.ResolveReference(&url.URL{Path: name}).String(),
+		})
+		urlset.URL, size = urlset.URL[:0], 0
+		return nil
+	}
+	for scanner.Scan() {
+		loc := strings.TrimSpace(scanner.Text())
+		if loc == "" {
+			continue
+		}
+		buf.Reset()
+		if err := xml.EscapeText(&buf, []byte(loc)); err != nil {
+			return n, err
+		}
+		entrySize := buf.Len() + len("  <url>\n    <loc></loc>\n  </url>\n")
+		if len(urlset.URL) == MaxURLs || size+entrySize+overhead > MaxBytes {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+		urlset.URL = append(urlset.URL, URL{Loc: loc})
+		size += entrySize
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, writeXML(filepath.Join(dir, "sitemap.xml"), smi)
+}
+
+// writeXML writes v as an indented XML document to filename.
+func writeXML(filename string, v any) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if _, err := io.WriteString(bw, xml.Header); err != nil {
+		f.Close()
+		return err
+	}
+	enc := xml.NewEncoder(bw)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.WriteString(bw, "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
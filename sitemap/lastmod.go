@@ -0,0 +1,46 @@
+package sitemap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lastmodLayouts are the W3C datetime formats allowed for lastmod.
+var lastmodLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// ParseLastmod parses a lastmod value, like 2006-01-02 or an RFC3339 date.
+func ParseLastmod(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range lastmodLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse lastmod: %q", s)
+}
+
+// inRange returns true, if a lastmod value is within the Since and Until
+// bounds of the cache. Missing or invalid values are always in range.
+func (c *Cache) inRange(lastmod string) bool {
+	if c.Since.IsZero() && c.Until.IsZero() {
+		return true
+	}
+	t, err := ParseLastmod(lastmod)
+	if err != nil {
+		return true
+	}
+	if !c.Since.IsZero() && t.Before(c.Since) {
+		return false
+	}
+	if !c.Until.IsZero() && t.After(c.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package sitemap
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// CacheLayout determines the location of a downloaded file in the cache dir.
+type CacheLayout int
+
+const (
+	LayoutHash CacheLayout = iota // hash of the URL, in sharded subdirectories
+	LayoutURL                     // host and path of the URL, readable, but prone to collisions
+)
+
+// urlFilename returns a relative path for a URL, mirroring host and path,
+// with characters other than letters, digits, dot, dash and underscore
+// replaced. A query is appended to the filename. Paths ending with a slash
+// are stored as "index".
+func urlFilename(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return sanitize(s)
+	}
+	parts := []string{sanitize(u.Host)}
+	for _, seg := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+		if seg != "" {
+			parts = append(parts, sanitize(seg))
+		}
+	}
+	if len(parts) == 1 || strings.HasSuffix(u.Path, "/") {
+		parts = append(parts, "index")
+	}
+	if u.RawQuery != "" {
+		parts[len(parts)-1] += "_" + sanitize(u.RawQuery)
+	}
+	if last := parts[len(parts)-1]; strings.HasSuffix(last, ".br") {
+		// Downloads are stored decompressed, while a .br extension would mark
+		// the file as compressed.
+		parts[len(parts)-1] = last + "_"
+	}
+	return path.Join(parts...)
+}
+
+// sanitize replaces characters unsafe in filenames with underscores. Names
+// consisting only of dots are replaced as well.
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+	if strings.Trim(s, ".") == "" {
+		return strings.Repeat("_", len(s))
+	}
+	return s
+}
@@ -0,0 +1,39 @@
+package sitemap
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// WriteMetrics writes the counters in the Prometheus text exposition format,
+// with names prefixed by "sitemapped_", e.g. to serve them for scraping.
+func (s *Stats) WriteMetrics(w io.Writer) error {
+	metrics := []struct {
+		name string
+		help string
+		v    *atomic.Int64
+	}{
+		{"sitemaps_total", "Sitemaps processed.", &s.Sitemaps},
+		{"urls_total", "URLs found in sitemaps.", &s.URLs},
+		{"fetch_errors_total", "Fetches that failed, after retries.", &s.FetchErrors},
+		{"failed_sitemaps_total", "Child sitemaps skipped due to errors.", &s.Failed},
+		{"cache_hits_total", "Files served from cache.", &s.CacheHits},
+		{"cache_misses_total", "Files downloaded.", &s.CacheMisses},
+		{"downloaded_bytes_total", "Bytes written to cache.", &s.BytesDownloaded},
+	}
+	for _, m := range metrics {
+		if err := WriteMetric(w, m.name, m.help, m.v.Load()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMetric writes a single counter in the Prometheus text exposition
+// format, with its name prefixed by "sitemapped_".
+func WriteMetric(w io.Writer, name, help string, v int64) error {
+	_, err := fmt.Fprintf(w, "# HELP sitemapped_%s %s\n# TYPE sitemapped_%s counter\nsitemapped_%s %d\n",
+		name, help, name, name, v)
+	return err
+}
@@ -0,0 +1,95 @@
+package sitemap
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryAfter wraps a Doer and retries failed requests and responses with
+// status 429 or 5xx. For 429 and 503, it waits as long as the server asks for
+// in the Retry-After header. Otherwise Backoff is used, if set, and the
+// request is not retried, if not. The wrapped Doer should not retry by
+// itself, or the retries multiply.
+type RetryAfter struct {
+	Doer       Doer
+	MaxRetries int
+	MaxWait    time.Duration                 // longer waits are not honored, if not zero
+	Backoff    func(retry int) time.Duration // used, if the server gives no hint
+	Logger     *log.Logger                   // if set, retries are logged here
+
+	mu sync.Mutex // serializes calls to Backoff, which need not be safe for concurrent use
+}
+
+// Do performs a request, retrying on errors, 429 and 5xx responses.
+func (r *RetryAfter) Do(req *http.Request) (*http.Response, error) {
+	for i := 1; ; i++ {
+		resp, err := r.Doer.Do(req)
+		if i > r.MaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		var (
+			wait   time.Duration
+			hinted bool
+			reason string
+		)
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+			wait, hinted = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if hinted && r.MaxWait > 0 && wait > r.MaxWait {
+				return resp, nil
+			}
+			reason = resp.Status
+		case resp.StatusCode >= 500:
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		if !hinted {
+			if r.Backoff == nil {
+				return resp, err
+			}
+			r.mu.Lock()
+			wait = r.Backoff(i)
+			r.mu.Unlock()
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if r.Logger != nil {
+			r.Logger.Printf("%s %s: attempt %d failed: %s, retrying in %s", req.Method, req.URL, i, reason, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date.
+func parseRetryAfter(s string, now time.Time) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(s); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
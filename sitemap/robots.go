@@ -0,0 +1,67 @@
+package sitemap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// IsRobotsURL returns true, if the URL points to a robots.txt file.
+func IsRobotsURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Path, "/robots.txt")
+}
+
+// RobotsURL returns the robots.txt URL for the host of a given URL. A bare
+// domain, like "example.com" is accepted as well, and https is assumed.
+func RobotsURL(s string) (string, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in %q", s)
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}).String(), nil
+}
+
+// SitemapsFromRobots returns the sitemap URLs advertised by "Sitemap:" lines
+// in the robots.txt at the given URL.
+func (c *Cache) SitemapsFromRobots(ctx context.Context, url string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fn, err := c.URL(ctx, url, &DownloadOpts{Force: c.Force})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		result  []string
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "sitemap") {
+			continue
+		}
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,632 @@
+// Package sitemap turns a single URL pointing to a sitemap into a list of
+// URL, resolving sitemapindex type sitemaps, too.
+package sitemap
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SitemapIndexEntry is an entry in a sitemap index style sitemap.
+type SitemapIndexEntry struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Text    string   `xml:",chardata"`
+	Loc     string   `xml:"loc"`               // https://core.ac.uk/sitema...
+	Lastmod string   `xml:"lastmod,omitempty"` // 2021-01-08, 2021-01-08, 2...
+}
+
+// Sitemapindex was generated 2024-07-01 15:50:15 by tir on reka with zek 0.1.24.
+type Sitemapindex struct {
+	XMLName xml.Name            `xml:"sitemapindex"`
+	Text    string              `xml:",chardata"`
+	Xmlns   string              `xml:"xmlns,attr"`
+	Sitemap []SitemapIndexEntry `xml:"sitemap"`
+}
+
+// Urlset was generated 2024-07-01 20:25:25 by tir on reka with zek 0.1.24.
+type Urlset struct {
+	XMLName xml.Name `xml:"urlset"`
+	Text    string   `xml:",chardata"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	URL     []URL    `xml:"url"`
+}
+
+// Namespaces of the sitemap protocol and supported sitemap extensions.
+const (
+	SitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	ImageNamespace   = "http://www.google.com/schemas/sitemap-image/1.1"
+	VideoNamespace   = "http://www.google.com/schemas/sitemap-video/1.1"
+	NewsNamespace    = "http://www.google.com/schemas/sitemap-news/0.9"
+	XHTMLNamespace   = "http://www.w3.org/1999/xhtml"
+)
+
+// URL is a single entry in a urlset.
+type URL struct {
+	Text       string  `xml:",chardata"`
+	Loc        string  `xml:"loc"`                  // https://core.ac.uk/displa...
+	Lastmod    string  `xml:"lastmod,omitempty"`    // 2021-01-08, 2005-01-01T12:00:00+00:00
+	Changefreq string  `xml:"changefreq,omitempty"` // always, hourly, daily, ...
+	Priority   string  `xml:"priority,omitempty"`   // 0.0 to 1.0
+	Images     []Image `xml:"http://www.google.com/schemas/sitemap-image/1.1 image"`
+	Videos     []Video `xml:"http://www.google.com/schemas/sitemap-video/1.1 video"`
+	Links      []Link  `xml:"http://www.w3.org/1999/xhtml link"`
+	News       *News   `xml:"http://www.google.com/schemas/sitemap-news/0.9 news"`
+}
+
+// DefaultPriority is the priority of a URL without one, as defined by the
+// sitemap protocol.
+const DefaultPriority = 0.5
+
+// PriorityValue returns the priority of the URL as a number, or
+// DefaultPriority, if it is missing or invalid.
+func (u *URL) PriorityValue() float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(u.Priority), 64)
+	if err != nil {
+		return DefaultPriority
+	}
+	return v
+}
+
+// Image is an image:image element of the image sitemap extension.
+type Image struct {
+	Loc string `xml:"http://www.google.com/schemas/sitemap-image/1.1 loc"`
+}
+
+// Link is a xhtml:link element, used to list alternate language versions of
+// a page.
+type Link struct {
+	Rel      string `xml:"rel,attr"`      // alternate
+	Hreflang string `xml:"hreflang,attr"` // de, en-US, x-default, ...
+	Href     string `xml:"href,attr"`
+}
+
+// Alternates returns the links with rel="alternate" and a hreflang.
+func (u *URL) Alternates() []Link {
+	var result []Link
+	for _, l := range u.Links {
+		if l.Rel == "alternate" && l.Hreflang != "" {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// News is a news:news element of the news sitemap extension.
+type News struct {
+	Publication struct {
+		Name     string `xml:"http://www.google.com/schemas/sitemap-news/0.9 name"`
+		Language string `xml:"http://www.google.com/schemas/sitemap-news/0.9 language"`
+	} `xml:"http://www.google.com/schemas/sitemap-news/0.9 publication"`
+	PublicationDate string `xml:"http://www.google.com/schemas/sitemap-news/0.9 publication_date"`
+	Title           string `xml:"http://www.google.com/schemas/sitemap-news/0.9 title"`
+}
+
+// Video is a video:video element of the video sitemap extension.
+type Video struct {
+	ThumbnailLoc string `xml:"http://www.google.com/schemas/sitemap-video/1.1 thumbnail_loc"`
+	Title        string `xml:"http://www.google.com/schemas/sitemap-video/1.1 title"`
+	ContentLoc   string `xml:"http://www.google.com/schemas/sitemap-video/1.1 content_loc"`
+	PlayerLoc    string `xml:"http://www.google.com/schemas/sitemap-video/1.1 player_loc"`
+}
+
+// WalkFunc is called for each URL found in a sitemap. The source is the URL of
+// the sitemap the entry was found in. If the function returns SkipAll, the
+// walk stops and pending downloads are cancelled.
+type WalkFunc func(source string, u *URL) error
+
+// ProgressFunc is called before the i-th of n child sitemaps of an index is
+// processed, with the location of that sitemap.
+type ProgressFunc func(i, n int, loc string)
+
+// SkipAll can be returned from a WalkFunc to stop the walk without error.
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// ErrNotIndex is returned, if a sitemap index was expected, but not found.
+var ErrNotIndex = errors.New("not a sitemap index")
+
+// Extract writes all URLs found in the sitemap or sitemap index at url to w,
+// one per line. Downloaded sitemaps are cached in DefaultCacheDir.
+func Extract(ctx context.Context, client Doer, url string, w io.Writer) error {
+	cache := &Cache{
+		Dir:       DefaultCacheDir,
+		Client:    client,
+		UserAgent: DefaultUserAgent,
+	}
+	return cache.Extract(ctx, url, w)
+}
+
+// Extract writes all URLs found in the sitemap or sitemap index at url to w,
+// one per line.
+func (c *Cache) Extract(ctx context.Context, url string, w io.Writer) error {
+	return c.Walk(ctx, url, func(_ string, u *URL) error {
+		_, err := fmt.Fprintln(w, strings.TrimSpace(u.Loc))
+		return err
+	})
+}
+
+// Walk calls fn for each URL found in the sitemap or sitemap index at url. If
+// url points to a robots.txt file, all sitemaps listed there are walked.
+func (c *Cache) Walk(ctx context.Context, url string, walkFn WalkFunc) error {
+	err := c.walk(ctx, url, newVisited(), walkFn)
+	if errors.Is(err, SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) walk(ctx context.Context, url string, seen *visited, walkFn WalkFunc) error {
+	if IsRobotsURL(url) {
+		locs, err := c.SitemapsFromRobots(ctx, url)
+		if err != nil {
+			return err
+		}
+		for _, loc := range locs {
+			if err := c.walk(ctx, loc, seen, walkFn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !seen.add(url) {
+		c.logf("skipping already visited sitemap: %s", url)
+		return nil
+	}
+	fn, err := c.URL(ctx, url, &DownloadOpts{Force: c.Force})
+	if err != nil {
+		return err
+	}
+	c.Stats.Sitemaps.Add(1)
+	return c.walkFile(ctx, url, fn, 0, seen, walkFn)
+}
+
+// WalkFile is like Walk, but reads the sitemap or sitemap index from a local
+// file, which may be gzip compressed. Child sitemaps of an index are fetched
+// as usual.
+func (c *Cache) WalkFile(ctx context.Context, filename string, walkFn WalkFunc) error {
+	c.Stats.Sitemaps.Add(1)
+	err := c.walkFile(ctx, filename, filename, 0, newVisited(), walkFn)
+	if errors.Is(err, SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// WalkReader is like WalkFile, but reads the sitemap from r, e.g. stdin. The
+// data is copied to a temporary file first, source is used as the location of
+// the sitemap.
+func (c *Cache) WalkReader(ctx context.Context, source string, r io.Reader, walkFn WalkFunc) error {
+	f, err := os.CreateTemp("", "sitemapped-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.Stats.Sitemaps.Add(1)
+	err = c.walkFile(ctx, source, f.Name(), 0, newVisited(), walkFn)
+	if errors.Is(err, SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// Index returns the entries of the sitemap index at url, without fetching
+// any of the sitemaps listed. If url is not a sitemap index, ErrNotIndex is
+// returned.
+func (c *Cache) Index(ctx context.Context, url string) (*Sitemapindex, error) {
+	fn, err := c.URL(ctx, url, &DownloadOpts{Force: c.Force})
+	if err != nil {
+		return nil, err
+	}
+	isIndex, err := isSitemapIndex(fn)
+	if err != nil {
+		return nil, err
+	}
+	if !isIndex {
+		return nil, fmt.Errorf("%s: %w", url, ErrNotIndex)
+	}
+	rc, err := openDecompressed(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	smi, err := decodeSitemapIndex(url, rc)
+	if err != nil {
+		return nil, err
+	}
+	c.resolveLocs(url, smi)
+	return smi, nil
+}
+
+// isSitemapIndex returns true, if the root element of a, possibly compressed,
+// file is a sitemapindex. Only the tokens up to the root element are read.
+func isSitemapIndex(filename string) (bool, error) {
+	rc, err := openDecompressed(filename)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	dec := newDecoder(rc)
+	root, err := firstStartElement(dec)
+	if err != nil {
+		return false, err
+	}
+	return root.Name.Local == "sitemapindex", nil
+}
+
+// decodeSitemapIndex parses a sitemap index, read from source.
+func decodeSitemapIndex(source string, r io.Reader) (*Sitemapindex, error) {
+	dec := newDecoder(r)
+	var smi Sitemapindex
+	if err := dec.Decode(&smi); err != nil {
+		return nil, newDecodeError(source, dec, err)
+	}
+	// Child locs are fetched, so surrounding whitespace, common in pretty
+	// printed sitemaps, would make them invalid URLs.
+	for i := range smi.Sitemap {
+		smi.Sitemap[i].Loc = strings.TrimSpace(smi.Sitemap[i].Loc)
+		smi.Sitemap[i].Lastmod = strings.TrimSpace(smi.Sitemap[i].Lastmod)
+	}
+	return &smi, nil
+}
+
+// resolveLocs resolves relative locs of the entries of an index against the
+// URL of the index, with ResolveRelative.
+func (c *Cache) resolveLocs(source string, smi *Sitemapindex) {
+	if !c.ResolveRelative {
+		return
+	}
+	base, err := url.Parse(source)
+	if err != nil || !base.IsAbs() {
+		return
+	}
+	for i, sm := range smi.Sitemap {
+		ref, err := url.Parse(sm.Loc)
+		if err != nil || ref.IsAbs() {
+			continue
+		}
+		smi.Sitemap[i].Loc = base.ResolveReference(ref).String()
+	}
+}
+
+// visited keeps track of sitemap URLs seen during a single traversal.
+type visited struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newVisited() *visited {
+	return &visited{seen: make(map[string]struct{})}
+}
+
+// add marks a URL as visited and returns false, if it was already visited.
+func (v *visited) add(s string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.seen[s]; ok {
+		return false
+	}
+	v.seen[s] = struct{}{}
+	return true
+}
+
+// childResult is the outcome of fetching a single child sitemap, the
+// filename of the cached copy is empty for skipped sitemaps.
+type childResult struct {
+	filename string
+	took     time.Duration // time spent fetching, counted against SitemapTimeout
+	err      error
+}
+
+// urlsFromSitemapIndex fetches all sitemaps in an index, using up to
+// c.Workers parallel downloads, and calls walkFn for each URL in index order.
+// Downloads happen in parallel, while parsing happens in order.
+// Nested indices are followed up to a depth of c.MaxDepth, sitemaps already
+// seen or with a lastmod outside of c.Since and c.Until are skipped.
+func (c *Cache) urlsFromSitemapIndex(ctx context.Context, smi *Sitemapindex, depth int, seen *visited, walkFn WalkFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, max(c.Workers, 1)) // limits fetched, but unconsumed results
+		results = make([]chan childResult, len(smi.Sitemap))
+	)
+	for i := range results {
+		results[i] = make(chan childResult, 1)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		taken := 0 // children passing the filters, for MaxSitemaps
+		for i, sm := range smi.Sitemap {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if !c.inRange(sm.Lastmod) {
+				results[i] <- childResult{}
+				continue
+			}
+			if c.Skip != nil && c.Skip(sm.Loc) {
+				c.debugf("skipping sitemap: %s", sm.Loc)
+				results[i] <- childResult{}
+				continue
+			}
+			if c.MaxSitemaps > 0 && taken >= c.MaxSitemaps {
+				c.debugf("skipping sitemap beyond max sitemaps: %s", sm.Loc)
+				results[i] <- childResult{}
+				continue
+			}
+			if !seen.add(sm.Loc) {
+				c.logf("skipping already visited sitemap: %s", sm.Loc)
+				results[i] <- childResult{}
+				continue
+			}
+			taken++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] <- c.fetchChild(ctx, sm)
+			}()
+		}
+	}()
+	var (
+		errs  []error
+		next  int   // index of the first result not consumed
+		cbErr error // error returned by walkFn, never skipped
+	)
+	walk := func(source string, u *URL) error {
+		if err := walkFn(source, u); err != nil {
+			cbErr = err
+			return err
+		}
+		return nil
+	}
+loop:
+	for i, sm := range smi.Sitemap {
+		var res childResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			break loop
+		}
+		next = i + 1
+		<-sem
+		if c.Progress != nil {
+			c.Progress(i+1, len(smi.Sitemap), sm.Loc)
+		}
+		err := res.err
+		if err == nil && res.filename != "" {
+			err = c.walkChild(ctx, sm.Loc, res, depth, seen, walk)
+			if err == nil && c.Completed != nil {
+				c.Completed(sm.Loc)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if c.SitemapTimeout > 0 && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			err = fmt.Errorf("%s: sitemap timeout of %v exceeded: %w", sm.Loc, c.SitemapTimeout, err)
+		}
+		if c.KeepGoing && cbErr == nil && ctx.Err() == nil {
+			c.Stats.Failed.Add(1)
+			c.logf("skipping failed sitemap: %v", err)
+			if c.OnError != nil {
+				c.OnError(sm.Loc, err)
+			}
+			continue
+		}
+		errs = append(errs, err)
+		break
+	}
+	cancel()
+	wg.Wait()
+	if len(errs) > 0 && errors.Is(errs[len(errs)-1], SkipAll) {
+		return SkipAll
+	}
+	// Collect errors of workers that were still running, when we stopped.
+	for _, ch := range results[next:] {
+		select {
+		case res := <-ch:
+			if res.err != nil && !errors.Is(res.err, context.Canceled) {
+				errs = append(errs, res.err)
+			}
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fetchChild downloads a single child sitemap, if required. In incremental
+// mode, the lastmod of the index entry decides, whether a cached copy is
+// still current. With SkipUnchanged, a child not downloaded anew is skipped.
+func (c *Cache) fetchChild(ctx context.Context, sm SitemapIndexEntry) childResult {
+	if err := ctx.Err(); err != nil {
+		return childResult{err: err}
+	}
+	if c.SitemapTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.SitemapTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	opts := &DownloadOpts{Force: c.Force}
+	if c.Incremental {
+		if t, err := ParseLastmod(sm.Lastmod); err == nil {
+			opts.Lastmod = t
+		}
+	}
+	fn, changed, err := c.fetch(ctx, sm.Loc, opts)
+	if err != nil {
+		return childResult{err: err}
+	}
+	if c.SkipUnchanged && !changed {
+		c.debugf("skipping unchanged sitemap: %s", sm.Loc)
+		return childResult{}
+	}
+	c.Stats.Sitemaps.Add(1)
+	return childResult{filename: fn, took: time.Since(start)}
+}
+
+// walkChild walks a fetched child sitemap of an index. With SitemapTimeout,
+// the walk stops, when the time left after fetching is used up. URLs passed
+// to walkFn up to then are not taken back.
+func (c *Cache) walkChild(ctx context.Context, loc string, res childResult, depth int, seen *visited, walkFn WalkFunc) error {
+	if c.SitemapTimeout <= 0 {
+		return c.walkFile(ctx, loc, res.filename, depth, seen, walkFn)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.SitemapTimeout-res.took)
+	defer cancel()
+	return c.walkFile(ctx, loc, res.filename, depth, seen, func(source string, u *URL) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return walkFn(source, u)
+	})
+}
+
+// walkFile parses a, possibly compressed, sitemap file, which may be an index
+// at the given depth or a plain text sitemap.
+func (c *Cache) walkFile(ctx context.Context, loc, filename string, depth int, seen *visited, walkFn WalkFunc) error {
+	rc, err := openDecompressed(filename)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if rc.encoding != "" {
+		c.debugf("%s: decompressing %s", loc, rc.encoding)
+	}
+	if text, err := isText(filename); err != nil {
+		return &DecodeError{Source: loc, Err: err}
+	} else if text {
+		return c.urlsFromText(loc, rc, walkFn)
+	}
+	isIndex, err := isSitemapIndex(filename)
+	if err != nil {
+		return &DecodeError{Source: loc, Err: err}
+	}
+	if !isIndex {
+		return c.urlsFromSitemap(loc, rc, walkFn)
+	}
+	if depth >= c.maxDepth() {
+		return fmt.Errorf("sitemap index nested deeper than %d levels: %s", c.maxDepth(), loc)
+	}
+	cr := &countingReader{r: rc}
+	smi, err := decodeSitemapIndex(loc, cr)
+	if err != nil {
+		return err
+	}
+	c.resolveLocs(loc, smi)
+	c.validate(loc, len(smi.Sitemap), cr.n)
+	return c.urlsFromSitemapIndex(ctx, smi, depth+1, seen, walkFn)
+}
+
+// urlsFromSitemap streams the URLs of a urlset and calls walkFn for each, so
+// memory use does not depend on the size of the sitemap. RSS and Atom feeds
+// are accepted as well.
+func (c *Cache) urlsFromSitemap(source string, r io.Reader, walkFn WalkFunc) error {
+	cr := &countingReader{r: r}
+	dec := newDecoder(cr)
+	root, err := firstStartElement(dec)
+	if err != nil {
+		return newDecodeError(source, dec, err)
+	}
+	switch root.Name.Local {
+	case "urlset":
+	case "rss", "feed":
+		return c.urlsFromFeed(source, dec, walkFn)
+	default:
+		return newDecodeError(source, dec, fmt.Errorf("expected element type <urlset> but have <%s>", root.Name.Local))
+	}
+	c.countExtensions(source, root)
+	var n int
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return newDecodeError(source, dec, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "url" {
+				if err := dec.Skip(); err != nil {
+					return newDecodeError(source, dec, err)
+				}
+				continue
+			}
+			var u URL
+			if err := dec.DecodeElement(&u, &t); err != nil {
+				return newDecodeError(source, dec, err)
+			}
+			n++
+			c.validateLoc(source, u.Loc)
+			c.Stats.URLs.Add(1)
+			if err := walkFn(source, &u); err != nil {
+				return err
+			}
+		case xml.EndElement: // end of urlset
+			c.validate(source, n, cr.n)
+			return nil
+		}
+	}
+}
+
+// countExtensions counts the sitemap extensions, whose namespaces are declared
+// on the root element of a urlset, in the stats of the cache.
+func (c *Cache) countExtensions(source string, root xml.StartElement) {
+	declared := make(map[string]bool)
+	for _, attr := range root.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			declared[strings.TrimSpace(attr.Value)] = true
+		}
+	}
+	var names []string
+	for _, ext := range []struct {
+		ns      string
+		name    string
+		counter *atomic.Int64
+	}{
+		{ImageNamespace, "image", &c.Stats.ImageSitemaps},
+		{VideoNamespace, "video", &c.Stats.VideoSitemaps},
+		{NewsNamespace, "news", &c.Stats.NewsSitemaps},
+		{XHTMLNamespace, "xhtml", &c.Stats.XHTMLSitemaps},
+	} {
+		if declared[ext.ns] {
+			ext.counter.Add(1)
+			names = append(names, ext.name)
+		}
+	}
+	if len(names) > 0 {
+		c.debugf("%s: declares extensions %s", source, strings.Join(names, ", "))
+	}
+}
+
+// firstStartElement returns the first start element, skipping over the XML
+// declaration, comments and other tokens.
+func firstStartElement(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se, nil
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package sitemap
+
+import "context"
+
+// URLEntry is a URL together with the sitemap it was found in.
+type URLEntry struct {
+	Source string // URL of the sitemap
+	URL
+}
+
+// Stream walks the sitemap or sitemap index at url in the background and sends
+// each URL found on the returned entries channel, as soon as it is parsed. The
+// entries channel is closed, when the walk is done. Then the error channel
+// receives the result of the walk, which is nil on success, and is closed as
+// well. To stop early, cancel ctx and drain the entries channel.
+func (c *Cache) Stream(ctx context.Context, url string) (<-chan URLEntry, <-chan error) {
+	var (
+		entries = make(chan URLEntry)
+		errc    = make(chan error, 1)
+	)
+	go func() {
+		err := c.Walk(ctx, url, func(source string, u *URL) error {
+			select {
+			case entries <- URLEntry{Source: source, URL: *u}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(entries)
+		errc <- err
+		close(errc)
+	}()
+	return entries, errc
+}
@@ -0,0 +1,65 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// isText returns true, if a, possibly compressed, sitemap file is a plain
+// text sitemap, with one URL per line, instead of XML.
+func isText(filename string) (bool, error) {
+	rc, err := openDecompressed(filename)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	head, err := bufio.NewReader(rc).Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return false, err
+	}
+	return looksLikeText(head), nil
+}
+
+// looksLikeText returns true, if the beginning of a file is an absolute http
+// or https URL.
+func looksLikeText(head []byte) bool {
+	head = bytes.TrimPrefix(head, utf8BOM)
+	head = bytes.ToLower(bytes.TrimLeft(head, " \t\r\n"))
+	return bytes.HasPrefix(head, []byte("http://")) || bytes.HasPrefix(head, []byte("https://"))
+}
+
+// urlsFromText calls walkFn for each URL of a plain text sitemap. Lines are
+// trimmed, empty lines are skipped and lines, which are not absolute http or
+// https URLs, are logged and skipped.
+func (c *Cache) urlsFromText(source string, r io.Reader, walkFn WalkFunc) error {
+	var (
+		cr      = &countingReader{r: r}
+		scanner = bufio.NewScanner(cr)
+		n       int
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.logf("%s: skipping invalid URL: %q", source, line)
+			continue
+		}
+		n++
+		c.validateLoc(source, line)
+		c.Stats.URLs.Add(1)
+		if err := walkFn(source, &URL{Loc: line}); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &DecodeError{Source: source, Err: err}
+	}
+	c.validate(source, n, cr.n)
+	return nil
+}
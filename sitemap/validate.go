@@ -0,0 +1,85 @@
+package sitemap
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Limits of a single sitemap file, as per sitemap protocol.
+const (
+	MaxURLs      = 50000            // max number of URLs or sitemaps in a file
+	MaxBytes     = 50 * 1024 * 1024 // max uncompressed size
+	MaxURLLength = 2048             // max length of a loc, as accepted by search engines
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// DefaultMaxSize is the default limit for a single download.
+const DefaultMaxSize = 512 * 1024 * 1024
+
+// ErrTooLarge is returned, if a download exceeds the maximum size.
+var ErrTooLarge = errors.New("response exceeds max size")
+
+// limitedReader reads from r, but fails with ErrTooLarge, once more than n
+// bytes have been read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := io.LimitReader(l.r, l.n+1).Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// maxSize returns the size limit for downloads, or -1 for no limit.
+func (c *Cache) maxSize() int64 {
+	switch {
+	case c.MaxSize > 0:
+		return c.MaxSize
+	case c.MaxSize < 0:
+		return -1
+	default:
+		return DefaultMaxSize
+	}
+}
+
+// validate logs a warning, if validation is enabled and a sitemap with the
+// given number of entries and uncompressed size exceeds protocol limits.
+func (c *Cache) validate(loc string, entries int, size int64) {
+	if !c.Validate {
+		return
+	}
+	if entries > MaxURLs {
+		c.logf("%s: %d entries exceed limit of %d", loc, entries, MaxURLs)
+	}
+	if size > MaxBytes {
+		c.logf("%s: uncompressed size of %d bytes exceeds limit of %d", loc, size, MaxBytes)
+	}
+}
+
+// validateLoc logs a warning, if the cache is in validating mode and a URL
+// found in sitemap source is longer than MaxURLLength.
+func (c *Cache) validateLoc(source, loc string) {
+	if !c.Validate {
+		return
+	}
+	if n := len(strings.TrimSpace(loc)); n > MaxURLLength {
+		c.logf("%s: URL of %d characters exceeds limit of %d: %.80s...", source, n, MaxURLLength, strings.TrimSpace(loc))
+	}
+}
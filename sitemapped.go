@@ -8,265 +8,733 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"compress/gzip"
-	"crypto/sha1"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
-	"encoding/xml"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
-	"slices"
+	"os/signal"
+	"regexp"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
-	"github.com/adrg/xdg"
+	"github.com/miku/sitemapped/sitemap"
 	"github.com/sethgrid/pester"
-	"golang.org/x/net/html/charset"
+	"golang.org/x/net/http2"
+	"golang.org/x/time/rate"
 )
 
 const Version = "0.1.5"
 
-// SitemapIndexEntry is an entry in a sitemap index style sitemap.
-type SitemapIndexEntry struct {
-	XMLName xml.Name `xml:"sitemap"`
-	Text    string   `xml:",chardata"`
-	Loc     string   `xml:"loc"`     // https://core.ac.uk/sitema...
-	Lastmod string   `xml:"lastmod"` // 2021-01-08, 2021-01-08, 2...
-}
-
-// Sitemapindex was generated 2024-07-01 15:50:15 by tir on reka with zek 0.1.24.
-type Sitemapindex struct {
-	XMLName xml.Name            `xml:"sitemapindex"`
-	Text    string              `xml:",chardata"`
-	Xmlns   string              `xml:"xmlns,attr"`
-	Sitemap []SitemapIndexEntry `xml:"sitemap"`
-}
-
-// Urlset was generated 2024-07-01 20:25:25 by tir on reka with zek 0.1.24.
-type Urlset struct {
-	XMLName xml.Name `xml:"urlset"`
-	Text    string   `xml:",chardata"`
-	Xmlns   string   `xml:"xmlns,attr"`
-	URL     []struct {
-		Text string `xml:",chardata"`
-		Loc  string `xml:"loc"` // https://core.ac.uk/displa...
-	} `xml:"url"`
-}
-
 var (
-	defaultCachePath = path.Join(xdg.CacheHome, "sitemap")
-
 	maxRetries  = flag.Int("r", 3, "max HTTP client retries")
-	cacheDir    = flag.String("cache-dir", defaultCachePath, "path to cache directory")
+	cacheDir    = flag.String("cache-dir", sitemap.DefaultCacheDir, "path to cache directory")
+	inputFile   = flag.String("input", "", "read sitemap URLs from this file, one per line, in addition to arguments, or from a column of a .csv file")
+	inputColumn = flag.String("input-column", "", "read -input as CSV with a header row and take sitemap URLs from this column, e.g. of a Search Console export, default is the first column")
+	sourceHdr   = flag.Bool("source-header", false, "write a '# input' line before the URLs of each input")
+	noCache     = flag.Bool("no-cache", false, "use a temporary cache directory, removed when done")
 	force       = flag.Bool("f", false, "force redownload, even if cached file exists")
+	maxSize     = flag.Int64("max-size", sitemap.DefaultMaxSize, "max bytes to download per file, 0 means no limit")
+	saveErrors  = flag.Bool("save-errors", false, "cache bodies of non-2xx responses and non-sitemap content, e.g. to inspect them")
+	refresh     = flag.Bool("refresh", false, "revalidate cached files with the server, using ETag and Last-Modified")
+	maxAge      = flag.Duration("max-age", 0, "redownload cached files older than this, 0 means no expiry")
+	maxDepth    = flag.Int("max-depth", sitemap.DefaultMaxDepth, "max levels of nested sitemap indices to follow")
 	showVersion = flag.Bool("version", false, "show version")
-	timeout     = flag.Duration("T", 15*time.Second, "timeout")
-	userAgent   = flag.String("ua", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", "user agent")
+	maxWait     = flag.Duration("max-retry-after", 5*time.Minute, "max time to wait, when a server asks for it with Retry-After")
+	timeout     = flag.Duration("T", 15*time.Second, "timeout per request")
+	smTimeout   = flag.Duration("sitemap-timeout", 0, "max time to fetch and parse a single child sitemap of an index, including retries, skipped with -keep-going, 0 means no limit")
+	deadline    = flag.Duration("deadline", 0, "max total run time, after which URLs found so far are written, 0 means no limit")
+	requestRate = flag.Float64("rate", 0, "max requests per second, 0 means no limit")
+	delay       = flag.Duration("delay", 0, "wait this long between requests, alternative to -rate")
+	proxy       = flag.String("proxy", "", "proxy URL (http, https or socks5), default is taken from HTTP_PROXY, HTTPS_PROXY env")
+	basicAuth   = flag.String("u", "", "basic auth credentials as user:pass")
+	cookie      = flag.String("cookie", "", "raw Cookie header value to send with each request")
+	cookieJar   = flag.String("cookie-jar", "", "load cookies from this file in Netscape format, as written by curl")
+	userAgent   = flag.String("ua", sitemap.DefaultUserAgent, "user agent")
+	changedOnly = flag.Bool("changed-only", false, "only walk child sitemaps downloaded anew, not served from cache or unchanged on the server, e.g. with -refresh")
+	raw         = flag.Bool("raw", false, "emit locs exactly as parsed, without trimming whitespace, e.g. to audit broken sitemaps")
+	maxRedirect = flag.Int("max-redirects", 10, "max number of redirects to follow, 0 means report redirects as errors with their target")
+	useNetrc    = flag.Bool("netrc", false, "use basic auth credentials per host from ~/.netrc, or the file in NETRC")
+	maxConns    = flag.Int("max-conns-per-host", 8, "max connections per host, including active ones, 0 means no limit")
+	maxIdle     = flag.Int("max-idle-conns-per-host", 4, "max idle connections per host kept for reuse")
+	http1       = flag.Bool("http1", false, "use HTTP/1.1 only, for servers misbehaving with HTTP/2")
+	metricsAddr = flag.String("metrics-addr", "", "serve counters of sitemaps, URLs, errors and cache hits in Prometheus text format at /metrics on this address, e.g. localhost:9090")
+	resolver    = flag.String("resolver", "", "resolve hostnames with the DNS server at this address, e.g. 10.0.0.53 or 10.0.0.53:5353, instead of the system resolver")
+	unixSocket  = flag.String("unix-socket", "", "send all requests through this unix domain socket, e.g. of a sidecar proxy")
+	errLogFile  = flag.String("errlog", "", "append a JSON record with url, error, timestamp and status for each failed sitemap to this file")
+	resumeFile  = flag.String("resume", "", "record inputs and child sitemaps done in this file and skip them on the next run, append output to that of earlier runs")
+	splitDir    = flag.String("split-dir", "", "write the URLs of each sitemap to a separate file in this directory, named by hash, listed in manifest.tsv")
+	uaFile      = flag.String("ua-file", "", "rotate user agents from this file, one per line, round-robin per request")
+	long        = flag.Bool("long", false, "emit loc, lastmod, changefreq and priority as tab separated columns")
+	jsonOutput  = flag.Bool("json", false, "emit one JSON object per URL")
+	csvOutput   = flag.Bool("csv", false, "emit CSV with a header row and loc, lastmod, changefreq, priority and source columns")
+	tmplText    = flag.String("template", "", "format each URL with this Go template, e.g. '{{.Loc}} {{.Lastmod}}', fields as in -json output")
+	images      = flag.Bool("images", false, "emit image locations of the image sitemap extension, too")
+	videos      = flag.Bool("videos", false, "emit video content and player locations of the video sitemap extension, too")
+	hreflang    = flag.Bool("hreflang", false, "emit alternate language URLs and their hreflang code, tab separated")
+	newsOnly    = flag.Bool("news", false, "only emit news sitemap entries, with publication date and title, tab separated")
+	withSource  = flag.Bool("with-source", false, "prefix each line with the URL of the sitemap it was found in, tab separated; always included with -json")
+	numWorkers  = flag.Int("j", 4, "number of child sitemaps to fetch in parallel")
+	match       = flag.String("match", "", "only emit URLs matching this regular expression")
+	onlyHost    = flag.String("host", "", "only emit URLs with this hostname")
+	changefreq  = flag.String("changefreq", "", "only emit URLs with one of these change frequencies, comma separated, e.g. daily,hourly")
+	minPriority = flag.Float64("min-priority", 0, "only emit URLs with at least this priority, URLs without one count as 0.5")
+	exclude     = flag.String("exclude", "", "do not emit URLs matching this regular expression")
+	normalize   = flag.Bool("normalize", false, "canonicalize URLs: lowercase scheme and host, strip default ports, resolve dot segments")
+	relative    = flag.Bool("resolve-relative", false, "resolve relative locs against the URL of their sitemap")
+	dedupe      = flag.Bool("dedupe", false, "suppress duplicate URLs, keeps all URLs seen in memory")
+	outputFile  = flag.String("o", "", "write URLs to this file instead of stdout")
+	gzipOutput  = flag.Bool("gzip", false, "gzip compress output, implied if -o ends with .gz")
+	validate    = flag.Bool("validate", false, "warn about sitemaps exceeding the protocol limits of 50000 URLs or 50MB and URLs longer than 2048 characters")
+	indexOnly   = flag.Bool("index-only", false, "only list sitemaps and their lastmod of a sitemap index, tab separated, without fetching them")
+	listIndex   = flag.Bool("list-sitemaps", false, "only list the locs of the sitemaps of a sitemap index, without fetching them")
+	since       = flag.String("since", "", "skip child sitemaps with a lastmod before this date, e.g. 2006-01-02 or RFC3339")
+	until       = flag.String("until", "", "skip child sitemaps with a lastmod after this date, e.g. 2006-01-02 or RFC3339")
+	sortURLs    = flag.Bool("sort", false, "sort URLs, keeps all URLs in memory")
+	sortHost    = flag.Bool("sort-host", false, "sort URLs by hostname, then path, keeps all URLs in memory")
+	shuffle     = flag.Bool("shuffle", false, "emit URLs in random order, keeps all URLs in memory, or a random sample of -limit URLs")
+	seed        = flag.Int64("seed", 0, "random seed for -shuffle, for reproducible output, 0 means random")
+	showStats   = flag.Bool("stats", false, "write a summary of sitemaps, URLs, cache usage and time to stderr")
+	check       = flag.Bool("check", false, "issue a HEAD request for each URL and emit its status code, tab separated, uses -j requests in parallel")
+	countOnly   = flag.Bool("count", false, "only write the number of URLs found, after applying filters")
+	limit       = flag.Int("limit", 0, "stop after emitting this many URLs, 0 means no limit")
+	maxSitemaps = flag.Int("max-sitemaps", 0, "only process the first this many child sitemaps of each index, after -since and -until, 0 means no limit")
+	robots      = flag.Bool("robots", false, "discover sitemaps from robots.txt of the given URL or domain")
+	keepGoing   = flag.Bool("keep-going", false, "log and skip child sitemaps that fail, exit with non-zero status at the end")
+	progress    = flag.Bool("progress", false, "write progress of child sitemaps and URL count to stderr")
+	shardDepth  = flag.Int("shard-depth", 1, "levels of cache subdirectories, named by two hex characters of the cache key each")
+	hashName    = flag.String("hash", "sha1", "hash function for cache keys, sha1 or sha256")
+	layout      = flag.String("cache-layout", "hash", "cache file names, hash or url, which mirrors host and path of the URL, e.g. for browsing")
+	cacheGC     = flag.Duration("cache-gc", 0, "remove leftover temporary download files older than this age from cache dir")
+	incremental = flag.Bool("incremental", false, "redownload child sitemaps only if their lastmod in the index is newer than the cached copy")
+	backoffName = flag.String("backoff", "jitter", "wait between retries, exponential or jitter, which varies exponential waits by up to a third to spread out retries")
+
+	insecure bool
+	verbose  bool
+	headers  = make(headerFlag)
+	rewrites = make(hostFlag)
+
+	// testTransport, if set, replaces the HTTP transport, e.g. to stub or
+	// count requests in tests of run, while redirects and retries are handled
+	// as usual.
+	testTransport http.RoundTripper
 )
 
+func init() {
+	flag.Var(headers, "H", "additional request header in the form 'Key: Value', can be repeated")
+	flag.Var(rewrites, "rewrite-host", "rewrite the host of emitted URLs in the form 'OLD=NEW', e.g. www.example.com=example.com, can be repeated")
+	flag.BoolVar(&insecure, "k", false, "skip TLS certificate verification (shorthand for -insecure)")
+	flag.BoolVar(&insecure, "insecure", false, "skip TLS certificate verification")
+	flag.BoolVar(raw, "no-trim", false, "same as -raw")
+	flag.BoolVar(&verbose, "v", false, "log fetches, cache hits and misses, retries and decompression (shorthand for -debug)")
+	flag.BoolVar(&verbose, "debug", false, "log fetches, cache hits and misses, retries and decompression")
+}
+
 func main() {
 	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the command, as given by flags. Output collected before an error is
+// flushed, so it is not lost, e.g. when writing to a pipe.
+func run() error {
+	started := time.Now()
 	if *showVersion {
 		fmt.Println(Version)
-		os.Exit(0)
+		return nil
 	}
-	if flag.NArg() == 0 {
-		log.Fatal("a sitemap.xml URL is required")
+	if *cacheGC > 0 {
+		c := &sitemap.Cache{Dir: *cacheDir}
+		if _, err := c.RemoveStale(*cacheGC); err != nil {
+			return err
+		}
+		if flag.NArg() == 0 {
+			return nil
+		}
 	}
-	if err := os.MkdirAll(*cacheDir, 755); err != nil {
-		log.Fatal(err)
+	switch flag.Arg(0) {
+	case "cache":
+		if err := cacheCommand(*cacheDir, flag.Args()[1:]); err != nil {
+			return err
+		}
+		return nil
+	case "generate":
+		if err := generateCommand(flag.Args()[1:]); err != nil {
+			return err
+		}
+		return nil
+	}
+	if flag.NArg() == 0 && *inputFile == "" {
+		return errors.New("a sitemap.xml URL is required")
+	}
+	var (
+		matchRe, excludeRe *regexp.Regexp
+		tmpl               *template.Template
+		err                error
+	)
+	if *match != "" {
+		if matchRe, err = regexp.Compile(*match); err != nil {
+			return fmt.Errorf("invalid -match: %v", err)
+		}
+	}
+	if *exclude != "" {
+		if excludeRe, err = regexp.Compile(*exclude); err != nil {
+			return fmt.Errorf("invalid -exclude: %v", err)
+		}
+	}
+	if *tmplText != "" {
+		if tmpl, err = template.New("url").Parse(*tmplText); err != nil {
+			return fmt.Errorf("invalid -template: %v", err)
+		}
+	}
+	cleanup := func() {} // removes the temporary cache of -no-cache
+	if *noCache {
+		dir, err := os.MkdirTemp("", "sitemapped-")
+		if err != nil {
+			return err
+		}
+		*cacheDir = dir
+		cleanup = func() { os.RemoveAll(dir) }
+	}
+	defer cleanup()
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		return err
 	}
 	transport := http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: insecure},
+		Proxy:               http.ProxyFromEnvironment,
+		MaxConnsPerHost:     *maxConns,
+		MaxIdleConnsPerHost: *maxIdle,
+	}
+	if *proxy != "" {
+		proxyURL, err := url.Parse(*proxy)
+		if err != nil {
+			return fmt.Errorf("invalid -proxy: %v", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme: %q", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if *resolver != "" {
+		if *unixSocket != "" {
+			return errors.New("-resolver cannot be used with -unix-socket")
+		}
+		addr := *resolver
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		d := net.Dialer{Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}}
+		transport.DialContext = d.DialContext
+	}
+	if *unixSocket != "" {
+		var d net.Dialer
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", *unixSocket)
+		}
+	}
+	if *http1 {
+		// A non-nil, empty map disables HTTP/2.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else if err := http2.ConfigureTransport(&transport); err != nil {
+		return err
 	}
 	client := &http.Client{
 		Timeout:   *timeout,
 		Transport: &transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > *maxRedirect {
+				// The redirect response is treated as an error, with its target.
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
 	}
-	httpClient := pester.NewExtendedClient(client)
-	httpClient.MaxRetries = *maxRetries
-	httpClient.Backoff = pester.ExponentialBackoff
-	httpClient.RetryOnHTTP429 = true
-	cache := &Cache{Client: httpClient, Dir: *cacheDir, UserAgent: *userAgent}
-	sitemapURL := flag.Arg(0) // sitemap or sitemapindex
-	fn, err := cache.URL(sitemapURL, nil)
-	if err != nil {
-		log.Fatal(err)
+	if testTransport != nil {
+		client.Transport = testTransport
 	}
-	isIndex, err := isSitemapIndex(fn)
-	if err != nil {
-		log.Fatal(err)
+	if *cookieJar != "" {
+		if client.Jar, err = loadCookieJar(*cookieJar); err != nil {
+			return err
+		}
 	}
-	f, err := os.Open(fn)
-	if err != nil {
-		log.Fatal(err)
+	if *cookie != "" {
+		http.Header(headers).Set("Cookie", *cookie)
 	}
-	defer f.Close()
-	bw := bufio.NewWriter(os.Stdout)
-	defer bw.Flush()
-	if isIndex {
-		err = urlsFromSitemapIndex(cache, f, bw)
-	} else {
-		err = urlsFromSitemap(f, bw)
+	var backoff pester.BackoffStrategy
+	switch *backoffName {
+	case "exponential":
+		backoff = pester.ExponentialBackoff
+	case "jitter":
+		backoff = pester.ExponentialJitterBackoff
+	default:
+		return fmt.Errorf("unsupported -backoff: %q", *backoffName)
 	}
-	if err != nil {
-		log.Fatal(err)
+	// Retries are left to RetryAfter alone, as it honors the hint of the
+	// server, which a retrying client underneath would not.
+	retryAfter := &sitemap.RetryAfter{
+		Doer:       client,
+		MaxRetries: *maxRetries,
+		MaxWait:    *maxWait,
+		Backoff:    backoff,
 	}
-}
-
-// isSitemapIndex returns true if this an index.
-func isSitemapIndex(filename string) (bool, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return false, err
+	if verbose {
+		retryAfter.Logger = log.Default()
 	}
-	defer f.Close()
-	buf := make([]byte, 1024)
-	_, err = f.Read(buf)
-	if err != nil {
-		return false, err
+	cache := &sitemap.Cache{
+		Client:     retryAfter,
+		Dir:        *cacheDir,
+		UserAgent:  *userAgent,
+		Header:     http.Header(headers),
+		Force:      *force,
+		Workers:    *numWorkers,
+		ShardDepth: *shardDepth,
+		Refresh:    *refresh,
+		MaxAge:     *maxAge,
+		MaxDepth:   *maxDepth,
+		Validate:   *validate,
+		SaveErrors: *saveErrors,
+		MaxSize:    *maxSize,
+		KeepGoing:  *keepGoing,
+		Logger:     log.Default(),
+		Debug:      verbose,
 	}
-	return bytes.Contains(buf, []byte("sitemapindex")), nil
-}
-
-func urlsFromSitemapIndex(cache *Cache, r io.Reader, w io.Writer) error {
-	dec := xml.NewDecoder(r)
-	dec.CharsetReader = charset.NewReaderLabel
-	var smi Sitemapindex
-	err := dec.Decode(&smi)
-	if err != nil {
-		return err
+	cache.Incremental = *incremental
+	cache.MaxSitemaps = *maxSitemaps
+	cache.SitemapTimeout = *smTimeout
+	cache.ResolveRelative = *relative
+	cache.SkipUnchanged = *changedOnly
+	if *uaFile != "" {
+		if cache.UserAgents, err = readLines(*uaFile); err != nil {
+			return err
+		}
+		if len(cache.UserAgents) == 0 {
+			return fmt.Errorf("no user agents in %s", *uaFile)
+		}
 	}
-	for _, sm := range smi.Sitemap {
-		fn, err := cache.URL(sm.Loc, &DownloadOpts{Force: *force})
+	switch {
+	case *requestRate > 0 && *delay > 0:
+		return errors.New("only one of -rate and -delay can be used")
+	case *requestRate > 0:
+		cache.Limiter = rate.NewLimiter(rate.Limit(*requestRate), 1)
+	case *delay > 0:
+		cache.Limiter = rate.NewLimiter(rate.Every(*delay), 1)
+	}
+	if *maxSize == 0 {
+		cache.MaxSize = -1
+	}
+	switch *layout {
+	case "hash":
+	case "url":
+		cache.Layout = sitemap.LayoutURL
+	default:
+		return fmt.Errorf("unsupported -cache-layout: %q", *layout)
+	}
+	switch *hashName {
+	case "sha1":
+	case "sha256":
+		cache.NewHash = sha256.New
+	default:
+		return fmt.Errorf("unsupported -hash: %q", *hashName)
+	}
+	if *since != "" {
+		if cache.Since, err = sitemap.ParseLastmod(*since); err != nil {
+			return fmt.Errorf("invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if cache.Until, err = sitemap.ParseLastmod(*until); err != nil {
+			return fmt.Errorf("invalid -until: %v", err)
+		}
+	}
+	if *basicAuth != "" {
+		cache.Username, cache.Password, _ = strings.Cut(*basicAuth, ":")
+	}
+	if *useNetrc {
+		filename, err := netrcPath()
 		if err != nil {
 			return err
 		}
-		// No defer for closeList, as we are exiting the program anyway, if we
-		// fail here. If that is to be changed, add a defer.
-		var closeList []io.Closer
-		var rc io.ReadCloser
-		f, err := os.Open(fn)
+		logins, err := loadNetrc(filename)
 		if err != nil {
 			return err
 		}
-		closeList = append(closeList, f)
-		switch {
-		case strings.HasSuffix(sm.Loc, ".gz"):
-			rc, err = gzip.NewReader(f)
-			if err != nil {
+		cache.Credentials = func(host string) (string, string, bool) {
+			l, ok := logins[host]
+			if !ok {
+				l, ok = logins[""]
+			}
+			return l.login, l.password, ok
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	var elog *errorLog
+	if *errLogFile != "" {
+		if elog, err = openErrorLog(*errLogFile); err != nil {
+			return err
+		}
+		defer elog.Close()
+		cache.OnError = func(loc string, err error) {
+			if werr := elog.Write(loc, err); werr != nil {
+				log.Printf("writing error log: %v", werr)
+			}
+		}
+	}
+	inputs := flag.Args()
+	if *inputFile != "" {
+		var lines []string
+		if isCSV(*inputFile, *inputColumn) {
+			lines, err = readColumn(*inputFile, *inputColumn)
+		} else {
+			lines, err = readLines(*inputFile)
+		}
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, lines...)
+	}
+	var (
+		out io.Writer = os.Stdout
+		af  *atomicFile
+	)
+	if *outputFile != "" {
+		if af, err = createAtomic(*outputFile); err != nil {
+			return err
+		}
+		out = af
+	}
+	var zw *gzip.Writer
+	if *gzipOutput || strings.HasSuffix(*outputFile, ".gz") {
+		zw = gzip.NewWriter(out)
+		out = zw
+	}
+	bw := bufio.NewWriter(out)
+	uw := newURLWriter(bw)
+	uw.match, uw.exclude, uw.tmpl = matchRe, excludeRe, tmpl
+	if *changefreq != "" {
+		if uw.freqs, err = parseChangefreqs(*changefreq); err != nil {
+			return fmt.Errorf("invalid -changefreq: %v", err)
+		}
+	}
+	if *check {
+		uw.check = newChecker(ctx, cache, bw, *numWorkers)
+	}
+	if *metricsAddr != "" {
+		ln, err := serveMetrics(*metricsAddr, cache, uw)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+	}
+	if *csvOutput {
+		uw.csv = csv.NewWriter(bw)
+		// With -split-dir, each file gets a header.
+		if *splitDir == "" {
+			if err := uw.csv.Write(csvHeader); err != nil {
 				return err
 			}
-			closeList = append(closeList, rc)
-		default:
-			rc = f
 		}
-		dec = xml.NewDecoder(rc)
-		dec.CharsetReader = charset.NewReaderLabel
-		var uset Urlset
-		if err := dec.Decode(&uset); err != nil {
-			log.Fatal(err)
+	}
+	if *splitDir != "" {
+		// A resumed run would replace the manifest of the earlier ones.
+		if *outputFile != "" || *check || *resumeFile != "" {
+			return errors.New("-split-dir cannot be combined with -o, -check or -resume")
+		}
+		ext := ".txt"
+		switch {
+		case *jsonOutput:
+			ext = ".jsonl"
+		case *csvOutput:
+			ext = ".csv"
+		}
+		if uw.split, err = newSplitter(*splitDir, ext); err != nil {
+			return err
+		}
+	}
+	if *seed != 0 {
+		uw.rand = rand.New(rand.NewSource(*seed))
+	}
+	if *dedupe {
+		uw.seen = make(map[string]struct{})
+	}
+	if *progress {
+		cache.Progress = func(i, n int, loc string) {
+			fmt.Fprintf(os.Stderr, "[%d/%d] fetching %s (%d urls)\n", i, n, loc, uw.emitted.Load())
+		}
+	}
+	var cp *checkpoint
+	if *resumeFile != "" {
+		if *outputFile != "" || *sortURLs || *sortHost || *shuffle {
+			return errors.New("-resume requires unbuffered output to stdout, not -o, -sort, -sort-host or -shuffle")
 		}
-		for _, u := range uset.URL {
-			_, err := fmt.Fprintln(w, strings.TrimSpace(u.Loc))
+		if cp, err = openCheckpoint(*resumeFile); err != nil {
+			return err
+		}
+		defer cp.Close()
+		cache.Skip = cp.Done
+		cache.Completed = func(loc string) {
+			// Only record a sitemap, once its URLs are written.
+			if uw.check != nil {
+				uw.check.Wait()
+			}
+			err := bw.Flush()
+			if err == nil && zw != nil {
+				err = zw.Flush()
+			}
+			if err == nil {
+				err = cp.Add(loc)
+			}
 			if err != nil {
+				log.Printf("writing checkpoint: %v", err)
+			}
+		}
+	}
+	// flush writes buffered and pending output.
+	flush := func() error {
+		if err := uw.Flush(); err != nil {
+			return err
+		}
+		if uw.split != nil {
+			if err := uw.split.Close(uw); err != nil {
+				return err
+			}
+		}
+		if uw.check != nil {
+			if err := uw.check.Close(); err != nil {
 				return err
 			}
 		}
-		slices.Reverse(closeList) // close gz RC before file
-		for _, c := range closeList {
-			if err := c.Close(); err != nil {
+		if *countOnly {
+			if _, err := fmt.Fprintln(bw, uw.count); err != nil {
 				return err
 			}
 		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if zw != nil {
+			return zw.Close()
+		}
+		return nil
+	}
+	for _, input := range inputs {
+		if *limit > 0 && uw.count >= *limit && !*shuffle {
+			break
+		}
+		if cp != nil && cp.Done(input) {
+			log.Printf("skipping input done in an earlier run: %s", input)
+			continue
+		}
+		failed := cache.Stats.Failed.Load()
+		if *sourceHdr {
+			if uw.check != nil {
+				uw.check.Wait()
+			}
+			if _, err = fmt.Fprintf(bw, "# %s\n", input); err != nil {
+				break
+			}
+		}
+		err = processInput(ctx, cache, input, uw, bw)
+		if err != nil && elog != nil && ctx.Err() == nil {
+			cache.OnError(input, err)
+		}
+		if err != nil && *keepGoing && ctx.Err() == nil {
+			log.Printf("skipping failed input: %v", err)
+			cache.Stats.Failed.Add(1)
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+		if *sourceHdr {
+			// Keep sorted output of each input below its header.
+			if err = uw.Flush(); err != nil {
+				break
+			}
+		}
+		// Record a whole input as done, unless some of its sitemaps failed,
+		// it was cut short by -limit or it cannot be read again.
+		if cp != nil && input != "-" && cache.Stats.Failed.Load() == failed && (*limit == 0 || uw.count < *limit) {
+			cache.Completed(input)
+		}
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("deadline of %s reached, output is incomplete", *deadline)
+		err = nil
 	}
-	return nil
-}
-
-func urlsFromSitemap(r io.Reader, w io.Writer) error {
-	dec := xml.NewDecoder(r)
-	dec.CharsetReader = charset.NewReaderLabel
-	var urlset Urlset
-	err := dec.Decode(&urlset)
 	if err != nil {
+		// An output file only appears after a complete run, other output
+		// keeps the URLs found so far.
+		if af != nil {
+			af.Abort()
+		} else if ferr := flush(); ferr != nil {
+			log.Print(ferr)
+		}
 		return err
 	}
-	for _, u := range urlset.URL {
-		_, err := fmt.Fprintln(w, strings.TrimSpace(u.Loc))
-		if err != nil {
+	if err := flush(); err != nil {
+		if af != nil {
+			af.Abort()
+		}
+		return err
+	}
+	if af != nil {
+		if err := af.Close(); err != nil {
 			return err
 		}
 	}
+	if *showStats {
+		fmt.Fprintf(os.Stderr, "sitemaps=%d urls=%d failed=%d cache_hits=%d cache_misses=%d bytes=%d image=%d video=%d news=%d xhtml=%d elapsed=%s\n",
+			cache.Stats.Sitemaps.Load(),
+			uw.count,
+			cache.Stats.Failed.Load(),
+			cache.Stats.CacheHits.Load(),
+			cache.Stats.CacheMisses.Load(),
+			cache.Stats.BytesDownloaded.Load(),
+			cache.Stats.ImageSitemaps.Load(),
+			cache.Stats.VideoSitemaps.Load(),
+			cache.Stats.NewsSitemaps.Load(),
+			cache.Stats.XHTMLSitemaps.Load(),
+			time.Since(started).Round(time.Millisecond))
+	}
+	if n := cache.Stats.Failed.Load(); n > 0 {
+		return fmt.Errorf("%d sitemaps failed", n)
+	}
 	return nil
 }
 
-type Cache struct {
-	Dir       string
-	Client    Doer
-	UserAgent string
-}
-
-type DownloadOpts struct {
-	Filename string // a specific filename to use, if any
-	Force    bool   // attempt redownload in any case
+// processInput writes the URLs found in a single input, which may be a
+// sitemap, sitemap index, robots.txt, bare domain, local file or "-" for stdin.
+func processInput(ctx context.Context, cache *sitemap.Cache, input string, uw *urlWriter, w io.Writer) error {
+	local := isLocal(input)
+	if local && (*indexOnly || *listIndex) {
+		return fmt.Errorf("-index-only and -list-sitemaps require a URL, got %s", input)
+	}
+	if !local && (*robots || !strings.Contains(input, "/")) {
+		robotsURL, err := sitemap.RobotsURL(input)
+		if err != nil {
+			return err
+		}
+		input = robotsURL
+	}
+	switch {
+	case *indexOnly || *listIndex:
+		return writeIndex(ctx, cache, input, w)
+	case *resumeFile != "" && !local:
+		// Sitemaps are recorded as done from the walk, so URLs must be
+		// written synchronously.
+		return cache.Walk(ctx, input, uw.WriteURL)
+	case input == "-":
+		return cache.WalkReader(ctx, "-", os.Stdin, uw.WriteURL)
+	case local:
+		return cache.WalkFile(ctx, input, uw.WriteURL)
+	default:
+		return streamURLs(ctx, cache, input, uw)
+	}
 }
 
-// URL returns the path to cached file for a given URL. If force is true,
-// redownload, even if copy exists.
-func (c *Cache) URL(url string, opts *DownloadOpts) (string, error) {
-	dir := c.Dir
-	if opts == nil || opts.Filename == "" {
-		h := sha1.New()
-		_, _ = h.Write([]byte(url))
-		digest := fmt.Sprintf("%x", h.Sum(nil))
-		shard := digest[:2]
-		opts = &DownloadOpts{Filename: digest}
-		dir = path.Join(c.Dir, shard)
-	}
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return "", err
-		}
-	}
-	dst := path.Join(dir, opts.Filename)
-	if _, err := os.Stat(dst); os.IsNotExist(err) || opts.Force {
-		if err := DownloadFile(c.Client, url, dst, c.UserAgent); err != nil {
-			return "", err
-		}
-	}
-	return dst, nil
+// streamURLs writes the URLs of the sitemap or sitemap index at url, as they
+// are received from the cache.
+func streamURLs(ctx context.Context, cache *sitemap.Cache, url string, uw *urlWriter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries, errc := cache.Stream(ctx, url)
+	for e := range entries {
+		if err := uw.WriteURL(e.Source, &e.URL); err != nil {
+			cancel()
+			for range entries {
+			}
+			<-errc
+			if errors.Is(err, sitemap.SkipAll) {
+				return nil
+			}
+			return err
+		}
+	}
+	return <-errc
 }
 
-type Doer interface {
-	Do(*http.Request) (*http.Response, error)
+// readLines returns the non-empty lines of a file, ignoring lines starting
+// with "#".
+func readLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		lines   []string
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
 }
 
-// DownloadFile retrieves a file from URL, atomically.
-func DownloadFile(client Doer, url string, dst string, userAgent string) error {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+// isLocal returns true, if the argument refers to stdin or an existing local
+// file, instead of a URL.
+func isLocal(arg string) bool {
+	if arg == "-" {
+		return true
 	}
-	req.Header.Set("User-Agent", userAgent)
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return false
 	}
-	defer resp.Body.Close()
-	// tempfile, same path, so assume save to atomically rename(2).
-	tmpf := dst + ".wip"
-	f, err := os.OpenFile(tmpf, os.O_CREATE|os.O_WRONLY, 0644)
+	_, err := os.Stat(arg)
+	return err == nil
+}
+
+// writeIndex writes the sitemaps listed in a sitemap index, with their lastmod,
+// unless only locs are requested.
+func writeIndex(ctx context.Context, cache *sitemap.Cache, url string, w io.Writer) error {
+	smi, err := cache.Index(ctx, url)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(f, resp.Body)
-	if err := f.Close(); err != nil {
-		return err
+	for _, sm := range smi.Sitemap {
+		var err error
+		loc := prepareLoc(url, sm.Loc)
+		if *listIndex {
+			_, err = fmt.Fprintln(w, loc)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\t%s\n", loc, sm.Lastmod)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return os.Rename(tmpf, dst)
+	return nil
 }
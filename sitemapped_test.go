@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// runArgs calls run with args as command line, after resetting the flags of
+// the command to their defaults.
+func runArgs(t *testing.T, args ...string) error {
+	t.Helper()
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			_ = f.Value.Set(f.DefValue)
+		}
+	})
+	clear(headers)
+	clear(rewrites)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return run()
+}
+
+// writeFile writes data to a file in dir and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCacheDirMode(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "nested", "cache")
+	input := writeFile(t, dir, "sitemap.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>https://example.com/</loc></url>
+</urlset>`)
+	if err := runArgs(t, "-cache-dir", cacheDir, "-o", filepath.Join(dir, "out.txt"), input); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A decimal 755 would be 01363, with the sticky bit and without owner
+	// read permission. The umask may clear bits of group and others.
+	mode := fi.Mode()
+	if mode&os.ModeSticky != 0 || mode.Perm()&0700 != 0700 || mode.Perm()&^0755 != 0 {
+		t.Errorf("cache dir mode: got %v, want drwxr-xr-x", mode)
+	}
+}
+
+// countingTransport counts requests by path.
+type countingTransport struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.counts[req.URL.Path]++
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestServer serves files by path, with placeholder {{.URL}} replaced by
+// the URL of the server. Paths ending in .gz are gzip compressed. Requests
+// are counted by the returned transport, which is used by run.
+func newTestServer(t *testing.T, files map[string]string, handlers map[string]http.HandlerFunc) (*httptest.Server, *countingTransport) {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	for path, data := range files {
+		body := []byte(strings.ReplaceAll(data, "{{.URL}}", srv.URL))
+		if strings.HasSuffix(path, ".gz") {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := zw.Write(body); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			body = buf.Bytes()
+		}
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		})
+	}
+	for path, h := range handlers {
+		mux.HandleFunc(path, h)
+	}
+	ct := &countingTransport{counts: make(map[string]int)}
+	testTransport = ct
+	t.Cleanup(func() { testTransport = nil })
+	return srv, ct
+}
+
+// runURLs runs the command with args in a new cache dir and returns the
+// lines written.
+func runURLs(t *testing.T, args ...string) ([]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	args = append([]string{"-cache-dir", filepath.Join(dir, "cache"), "-o", out}, args...)
+	err := runArgs(t, args...)
+	b, rerr := os.ReadFile(out)
+	if errors.Is(rerr, fs.ErrNotExist) {
+		return nil, err
+	}
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return strings.Fields(string(b)), err
+}
+
+// urlset returns a sitemap with the given locs.
+func urlset(locs ...string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, loc := range locs {
+		fmt.Fprintf(&sb, "<url><loc>%s</loc></url>\n", loc)
+	}
+	sb.WriteString("</urlset>\n")
+	return sb.String()
+}
+
+// index returns a sitemap index with the given sitemap paths, relative to
+// the test server.
+func index(paths ...string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, p := range paths {
+		fmt.Fprintf(&sb, "<sitemap><loc>{{.URL}}%s</loc></sitemap>\n", p)
+	}
+	sb.WriteString("</sitemapindex>\n")
+	return sb.String()
+}
+
+func TestRun(t *testing.T) {
+	files := map[string]string{
+		"/index.xml":     index("/a.xml", "/nested.xml"),
+		"/nested.xml":    index("/b.xml.gz", "/c.xml"),
+		"/a.xml":         urlset("https://example.com/a1", "https://example.com/a2"),
+		"/b.xml.gz":      urlset("https://example.com/b1"),
+		"/c.xml":         urlset("https://example.com/c1", "https://example.com/c2"),
+		"/broken.xml":    index("/a.xml", "/missing.xml", "/c.xml"),
+		"/redirect.xml":  index("/moved.xml"),
+		"/flaky-idx.xml": index("/flaky.xml"),
+	}
+	var flaky atomic.Int64
+	handlers := map[string]http.HandlerFunc{
+		"/moved.xml": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/a.xml", http.StatusMovedPermanently)
+		},
+		// Fails once, with a hint to retry immediately.
+		"/flaky.xml": func(w http.ResponseWriter, r *http.Request) {
+			if flaky.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, urlset("https://example.com/f1"))
+		},
+	}
+	cases := []struct {
+		name     string
+		args     []string
+		path     string
+		want     []string
+		wantErr  bool
+		requests map[string]int // if set, expected requests by path
+	}{
+		{
+			name: "nested index with gzip child",
+			path: "/index.xml",
+			want: []string{
+				"https://example.com/a1",
+				"https://example.com/a2",
+				"https://example.com/b1",
+				"https://example.com/c1",
+				"https://example.com/c2",
+			},
+		},
+		{
+			name: "limit",
+			args: []string{"-limit", "3"},
+			path: "/index.xml",
+			want: []string{
+				"https://example.com/a1",
+				"https://example.com/a2",
+				"https://example.com/b1",
+			},
+		},
+		{
+			name:    "missing child",
+			path:    "/broken.xml",
+			wantErr: true,
+		},
+		{
+			name: "missing child with keep going",
+			args: []string{"-keep-going"},
+			path: "/broken.xml",
+			want: []string{
+				"https://example.com/a1",
+				"https://example.com/a2",
+				"https://example.com/c1",
+				"https://example.com/c2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "redirected child",
+			path: "/redirect.xml",
+			want: []string{"https://example.com/a1", "https://example.com/a2"},
+		},
+		{
+			name: "retry after",
+			args: []string{"-r", "2"},
+			path: "/flaky-idx.xml",
+			want: []string{"https://example.com/f1"},
+			requests: map[string]int{
+				"/flaky-idx.xml": 1,
+				"/flaky.xml":     2,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv, ct := newTestServer(t, files, handlers)
+			got, err := runURLs(t, append(c.args, srv.URL+c.path)...)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %v", err, c.wantErr)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+			for path, n := range c.requests {
+				if ct.counts[path] != n {
+					t.Errorf("got %d requests for %s, want %d", ct.counts[path], path, n)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSendsNoCredentials(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers = make(map[string]http.Header) // by method
+	)
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers[r.Method] = r.Header.Clone()
+	}
+	srv, _ := newTestServer(t, nil, map[string]http.HandlerFunc{
+		"/sitemap.xml": func(w http.ResponseWriter, r *http.Request) {
+			record(r)
+			io.WriteString(w, urlset("http://"+r.Host+"/page"))
+		},
+		"/page": func(w http.ResponseWriter, r *http.Request) {
+			record(r)
+		},
+	})
+	_, err := runURLs(t, "-check", "-u", "user:secret", "-H", "X-Token: secret", "-cookie", "session=secret", srv.URL+"/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"Authorization", "X-Token", "Cookie"} {
+		if headers[http.MethodGet].Get(k) == "" {
+			t.Errorf("sitemap request: missing header %s", k)
+		}
+		if v := headers[http.MethodHead].Get(k); v != "" {
+			t.Errorf("check request: got header %s: %s", k, v)
+		}
+	}
+}
+
+func TestRewriteHost(t *testing.T) {
+	rewrites := hostFlag{"www.example.com": "example.com", "old.example.com": "localhost:8080"}
+	cases := []struct {
+		s, want string
+	}{
+		{"https://www.example.com/a?b=c", "https://example.com/a?b=c"},
+		{"https://WWW.Example.com/a", "https://example.com/a"},
+		{"http://www.example.com:8000/a", "http://example.com:8000/a"},
+		{"http://old.example.com:8000/a", "http://localhost:8080/a"},
+		{"https://other.example.com/a", "https://other.example.com/a"},
+		{"/relative", "/relative"},
+	}
+	for _, c := range cases {
+		if got := rewriteHost(c.s, rewrites); got != c.want {
+			t.Errorf("rewriteHost(%q): got %q, want %q", c.s, got, c.want)
+		}
+	}
+}
+
+// runStdout runs the command with args in a new cache dir and returns the
+// lines written to stdout.
+func runStdout(t *testing.T, args ...string) ([]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = stdout }()
+	err = runArgs(t, append([]string{"-cache-dir", filepath.Join(dir, "cache")}, args...)...)
+	b, rerr := os.ReadFile(f.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return strings.Fields(string(b)), err
+}
+
+func TestResume(t *testing.T) {
+	srv, ct := newTestServer(t, map[string]string{
+		"/a.xml":      urlset("https://example.com/a1"),
+		"/b.xml":      urlset("https://example.com/b1"),
+		"/index.xml":  index("/b.xml"),
+		"/broken.xml": index("/b.xml", "/missing.xml"),
+	}, nil)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	inputs := []string{srv.URL + "/a.xml", srv.URL + "/index.xml", srv.URL + "/broken.xml"}
+	args := append([]string{"-resume", checkpoint, "-keep-going"}, inputs...)
+	got, err := runStdout(t, args...)
+	if err == nil {
+		t.Fatal("got no error for missing child")
+	}
+	want := []string{"https://example.com/a1", "https://example.com/b1", "https://example.com/b1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("first run: got %q, want %q", got, want)
+	}
+	clear(ct.counts)
+	// Only the input with a failed child is walked again.
+	if got, _ = runStdout(t, args...); len(got) != 0 {
+		t.Errorf("second run: got %q, want no URLs", got)
+	}
+	for path, n := range map[string]int{"/a.xml": 0, "/index.xml": 0, "/broken.xml": 1, "/b.xml": 0, "/missing.xml": 1} {
+		if ct.counts[path] != n {
+			t.Errorf("second run: got %d requests for %s, want %d", ct.counts[path], path, n)
+		}
+	}
+}
+
+func TestSplitDirResume(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "sitemap.xml", urlset("https://example.com/"))
+	err := runArgs(t, "-split-dir", filepath.Join(dir, "split"), "-resume", filepath.Join(dir, "checkpoint"), input)
+	if err == nil || !strings.Contains(err.Error(), "-resume") {
+		t.Errorf("got error %v, want error about -resume", err)
+	}
+}
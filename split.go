@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// splitter writes the URLs of each sitemap to a separate file in a directory,
+// named by the hash of the sitemap URL, and lists the files in a manifest.
+type splitter struct {
+	dir    string
+	ext    string            // filename extension, by output format
+	source string            // sitemap of the current file
+	f      *os.File          // current file
+	bw     *bufio.Writer     // buffers writes to the current file
+	files  map[string]string // filename by sitemap
+	counts map[string]int    // number of URLs by sitemap
+	order  []string          // sitemaps in the order of their first URL
+}
+
+func newSplitter(dir, ext string) (*splitter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &splitter{
+		dir:    dir,
+		ext:    ext,
+		files:  make(map[string]string),
+		counts: make(map[string]int),
+	}, nil
+}
+
+// use directs the output of uw to the file of sitemap source, before a URL of
+// that sitemap is written.
+func (s *splitter) use(uw *urlWriter, source string) error {
+	s.counts[source]++
+	if s.f != nil && source == s.source {
+		return nil
+	}
+	if err := s.closeFile(uw); err != nil {
+		return err
+	}
+	name, ok := s.files[source]
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if !ok {
+		name = fmt.Sprintf("%x%s", sha1.Sum([]byte(source)), s.ext)
+		s.files[source] = name
+		s.order = append(s.order, source)
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, name), flags, 0644)
+	if err != nil {
+		return err
+	}
+	s.f, s.bw, s.source = f, bufio.NewWriter(f), source
+	uw.setOutput(s.bw)
+	if uw.csv != nil {
+		uw.csv = csv.NewWriter(s.bw)
+		if !ok {
+			return uw.csv.Write(csvHeader)
+		}
+	}
+	return nil
+}
+
+// closeFile flushes and closes the current file, if any.
+func (s *splitter) closeFile(uw *urlWriter) error {
+	if s.f == nil {
+		return nil
+	}
+	if uw.csv != nil {
+		uw.csv.Flush()
+		if err := uw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := s.bw.Flush(); err != nil {
+		return err
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Close closes the current file and writes the manifest, with filename,
+// number of URLs and sitemap URL, tab separated, per line.
+func (s *splitter) Close(uw *urlWriter) error {
+	if err := s.closeFile(uw); err != nil {
+		return err
+	}
+	af, err := createAtomic(filepath.Join(s.dir, "manifest.tsv"))
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(af)
+	for _, source := range s.order {
+		if _, err := fmt.Fprintf(bw, "%s\t%d\t%s\n", s.files[source], s.counts[source], source); err != nil {
+			af.Abort()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		af.Abort()
+		return err
+	}
+	return af.Close()
+}